@@ -0,0 +1,52 @@
+package brightbox
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+var accountIDPattern = regexp.MustCompile(`^acc-[a-z0-9]{5}$`)
+
+func isAccountID(account string) bool {
+	return accountIDPattern.MatchString(account)
+}
+
+// accountsNamed returns the accounts whose name matches, ignoring case
+func accountsNamed(accounts []brightbox.Account, name string) []brightbox.Account {
+	var result []brightbox.Account
+	for _, account := range accounts {
+		if strings.EqualFold(account.Name, name) {
+			result = append(result, account)
+		}
+	}
+	return result
+}
+
+func formatAccounts(accounts []brightbox.Account) string {
+	var list bytes.Buffer
+	for index, account := range accounts {
+		fmt.Fprintf(&list, "  %d) %s  %s\n", index+1, account.Id, account.Name)
+	}
+	return list.String()
+}
+
+// promptAccount asks for a choice from a numbered list of accounts on
+// the terminal.
+func promptAccount(accounts []brightbox.Account) (*brightbox.Account, error) {
+	question := "Brightbox Cloud accounts:\n" + formatAccounts(accounts) +
+		fmt.Sprintf("Select account [1-%d]: ", len(accounts))
+	answer, err := promptLine(question)
+	if err != nil {
+		return nil, err
+	}
+	choice, err := strconv.Atoi(answer)
+	if err != nil || choice < 1 || choice > len(accounts) {
+		return nil, fmt.Errorf("Invalid account selection %q", answer)
+	}
+	return &accounts[choice-1], nil
+}
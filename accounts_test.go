@@ -0,0 +1,44 @@
+package brightbox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+var testAccounts = []brightbox.Account{
+	{Resource: brightbox.Resource{Id: "acc-12345"}, Name: "Ops Team"},
+	{Resource: brightbox.Resource{Id: "acc-abcde"}, Name: "Development"},
+	{Resource: brightbox.Resource{Id: "acc-xyzab"}, Name: "ops team"},
+}
+
+func TestIsAccountID(t *testing.T) {
+	if !isAccountID("acc-12345") {
+		t.Error("Account ID not recognised")
+	}
+	for _, name := range []string{"", "Ops Team", "acc-123456", "acc-ABCDE"} {
+		if isAccountID(name) {
+			t.Errorf("%q treated as an account ID", name)
+		}
+	}
+}
+
+func TestAccountsNamed(t *testing.T) {
+	if result := accountsNamed(testAccounts, "development"); len(result) != 1 || result[0].Id != "acc-abcde" {
+		t.Errorf("Incorrect match: %v", result)
+	}
+	if result := accountsNamed(testAccounts, "OPS TEAM"); len(result) != 2 {
+		t.Errorf("Ambiguous name not matched twice: %v", result)
+	}
+	if result := accountsNamed(testAccounts, "Finance"); len(result) != 0 {
+		t.Errorf("Unknown name matched: %v", result)
+	}
+}
+
+func TestFormatAccounts(t *testing.T) {
+	result := formatAccounts(testAccounts)
+	if !strings.Contains(result, "  2) acc-abcde  Development\n") {
+		t.Errorf("Incorrect account list: %s", result)
+	}
+}
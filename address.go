@@ -0,0 +1,125 @@
+package brightbox
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	addressModeIPv6    = "ipv6"
+	addressModeIPv4    = "ipv4"
+	addressModeAuto    = "auto"
+	addressModePrivate = "private"
+
+	probeTimeout = 5 * time.Second
+)
+
+func validAddressMode(mode string) bool {
+	switch mode {
+	case "", addressModeIPv6, addressModeIPv4, addressModeAuto, addressModePrivate:
+		return true
+	}
+	return false
+}
+
+// addressMode works out how the server should be addressed. Machines
+// created before the address mode option existed fall back to the IPv6
+// setting. In auto mode the server is probed and the result kept for
+// the rest of this run only, so a change of network is picked up by
+// the next command.
+func (d *Driver) addressMode(server *brightbox.Server) (string, error) {
+	switch d.AddressMode {
+	case "":
+		if d.IPv6 {
+			return addressModeIPv6, nil
+		}
+		return addressModeIPv4, nil
+	case addressModeAuto:
+		if d.probedAddressMode == "" {
+			mode, reachable := d.probeAddressMode(server)
+			if !reachable {
+				return mode, nil
+			}
+			d.probedAddressMode = mode
+		}
+		return d.probedAddressMode, nil
+	default:
+		return d.AddressMode, nil
+	}
+}
+
+// probeAddressMode picks the first address mode that reaches the
+// server's SSH port. If none does, perhaps because sshd isn't up yet,
+// it falls back to the public IPv4 address if there is one, otherwise
+// IPv6, and reports the choice as unreachable so it is probed again.
+func (d *Driver) probeAddressMode(server *brightbox.Server) (string, bool) {
+	modes := []string{addressModeIPv6}
+	hosts := []string{d.ipv6Address(server)}
+	if len(server.CloudIPs) > 0 {
+		modes = append(modes, addressModeIPv4)
+		hosts = append(hosts, d.publicAddress(server))
+	}
+	index, err := firstReachable(hosts, d.SSHPort)
+	if err != nil {
+		fallback := modes[len(modes)-1]
+		log.Debugf("%s. Using %s address mode for %s until it is reachable", err, fallback, d.MachineID)
+		return fallback, false
+	}
+	log.Debugf("Selected %s address mode for %s", modes[index], d.MachineID)
+	return modes[index], true
+}
+
+// The address functions return the DNS name for each type of server
+// address, or the IP address itself if UseIPAddress is set and the
+// server details contain one.
+
+func (d *Driver) ipv6Address(server *brightbox.Server) string {
+	if d.UseIPAddress {
+		for _, iface := range server.Interfaces {
+			if iface.IPv6Address != "" {
+				return iface.IPv6Address
+			}
+		}
+	}
+	return ipv6Fqdn(server)
+}
+
+func (d *Driver) publicAddress(server *brightbox.Server) string {
+	if d.UseIPAddress && len(server.CloudIPs) > 0 && server.CloudIPs[0].PublicIP != "" {
+		return server.CloudIPs[0].PublicIP
+	}
+	return publicFqdn(server)
+}
+
+func (d *Driver) privateAddress(server *brightbox.Server) string {
+	if d.UseIPAddress {
+		for _, iface := range server.Interfaces {
+			if iface.IPv4Address != "" {
+				return iface.IPv4Address
+			}
+		}
+	}
+	return server.Fqdn
+}
+
+// firstReachable returns the index of the first host that accepts a TCP
+// connection on port.
+func firstReachable(hosts []string, port int) (int, error) {
+	for index, host := range hosts {
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+		log.Debugf("Probing %s", address)
+		conn, err := net.DialTimeout("tcp", address, probeTimeout)
+		if err != nil {
+			log.Debugf("%s is unreachable: %s", address, err)
+			continue
+		}
+		conn.Close()
+		return index, nil
+	}
+	return 0, fmt.Errorf("None of %v is reachable on port %d", hosts, port)
+}
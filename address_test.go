@@ -0,0 +1,154 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+func TestValidAddressMode(t *testing.T) {
+	for _, mode := range []string{"", "ipv6", "ipv4", "auto"} {
+		if !validAddressMode(mode) {
+			t.Errorf("Address mode %q rejected", mode)
+		}
+	}
+	if validAddressMode("ipv5") {
+		t.Error("Invalid address mode accepted")
+	}
+}
+
+func TestLegacyAddressMode(t *testing.T) {
+	driver := new(Driver)
+	server := new(brightbox.Server)
+	driver.IPv6 = true
+	if mode, _ := driver.addressMode(server); mode != addressModeIPv6 {
+		t.Errorf("Incorrect legacy IPv6 address mode: %s", mode)
+	}
+	driver.IPv6 = false
+	if mode, _ := driver.addressMode(server); mode != addressModeIPv4 {
+		t.Errorf("Incorrect legacy IPv4 address mode: %s", mode)
+	}
+	driver.AddressMode = addressModeIPv6
+	if mode, _ := driver.addressMode(server); mode != addressModeIPv6 {
+		t.Errorf("Explicit address mode not honoured: %s", mode)
+	}
+}
+
+func TestCachedAutoAddressMode(t *testing.T) {
+	driver := new(Driver)
+	driver.AddressMode = addressModeAuto
+	driver.probedAddressMode = addressModeIPv4
+	if mode, _ := driver.addressMode(new(brightbox.Server)); mode != addressModeIPv4 {
+		t.Errorf("Cached address mode not used: %s", mode)
+	}
+	config, err := json.Marshal(driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), addressModeIPv4) {
+		t.Errorf("Probed address mode saved in config: %s", config)
+	}
+}
+
+func TestUnreachableAutoAddressMode(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, portString, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	driver := new(Driver)
+	driver.AddressMode = addressModeAuto
+	driver.UseIPAddress = true
+	driver.SSHPort, _ = strconv.Atoi(portString)
+	server := getTestServer()
+	server.Interfaces[0].IPv6Address = "::1"
+	server.CloudIPs[0].PublicIP = "127.0.0.1"
+	mode, err := driver.addressMode(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != addressModeIPv4 {
+		t.Errorf("Incorrect fallback address mode: %s", mode)
+	}
+	if driver.probedAddressMode != "" {
+		t.Errorf("Unreachable address mode cached: %s", driver.probedAddressMode)
+	}
+}
+
+func TestFirstReachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, portString, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portString)
+	index, err := firstReachable([]string{"unreachable.invalid", "127.0.0.1"}, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 {
+		t.Errorf("Incorrect host selected: %d", index)
+	}
+	if _, err := firstReachable([]string{"unreachable.invalid"}, port); err == nil {
+		t.Error("Unreachable host not detected")
+	}
+}
+
+func getTestServer() *brightbox.Server {
+	return &brightbox.Server{
+		Resource: brightbox.Resource{
+			Id: "srv-testy",
+		},
+		Fqdn: "srv-testy.gb1.brightbox.com",
+		Interfaces: []brightbox.ServerInterface{
+			{
+				IPv4Address: "10.240.1.2",
+				IPv6Address: "2a02:1348:17c:4001:24:19ff:fef0:1002",
+			},
+		},
+		CloudIPs: []brightbox.CloudIP{
+			{
+				PublicIP: "109.107.35.1",
+			},
+		},
+	}
+}
+
+func TestAddressNames(t *testing.T) {
+	driver := new(Driver)
+	server := getTestServer()
+	if result := driver.ipv6Address(server); result != "ipv6.srv-testy.gb1.brightbox.com" {
+		t.Errorf("Incorrect IPv6 name: %s", result)
+	}
+	if result := driver.publicAddress(server); result != "public.srv-testy.gb1.brightbox.com" {
+		t.Errorf("Incorrect public name: %s", result)
+	}
+	if result := driver.privateAddress(server); result != "srv-testy.gb1.brightbox.com" {
+		t.Errorf("Incorrect private name: %s", result)
+	}
+}
+
+func TestIPAddresses(t *testing.T) {
+	driver := new(Driver)
+	driver.UseIPAddress = true
+	server := getTestServer()
+	if result := driver.ipv6Address(server); result != "2a02:1348:17c:4001:24:19ff:fef0:1002" {
+		t.Errorf("Incorrect IPv6 address: %s", result)
+	}
+	if result := driver.publicAddress(server); result != "109.107.35.1" {
+		t.Errorf("Incorrect public address: %s", result)
+	}
+	if result := driver.privateAddress(server); result != "10.240.1.2" {
+		t.Errorf("Incorrect private address: %s", result)
+	}
+	server.Interfaces = nil
+	if result := driver.ipv6Address(server); result != "ipv6.srv-testy.gb1.brightbox.com" {
+		t.Errorf("Missing IPv6 address did not fall back to name: %s", result)
+	}
+}
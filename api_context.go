@@ -0,0 +1,126 @@
+package brightbox
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/net/context"
+)
+
+// How long, in seconds, a single API call may take by default
+const defaultAPITimeout = 60
+
+// callContext is the context every API call runs under. It is cancelled
+// when the plugin is interrupted, and can be detached from that so
+// clean up calls still get through.
+type callContext struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newCallContext returns a context that is cancelled on the first
+// interrupt. Signal handling is then dropped, so a second interrupt
+// stops the plugin at once.
+func newCallContext() *callContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			log.Warn("Interrupted. Cancelling Brightbox API calls")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interrupts)
+	}()
+	return &callContext{ctx: ctx, cancel: cancel}
+}
+
+func (c *callContext) current() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+// detach replaces a cancelled context with a fresh one
+func (c *callContext) detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+	}
+}
+
+func (c *callContext) interrupted() bool {
+	return c.current().Err() != nil
+}
+
+// callContext returns the context for this plugin run, creating it on
+// first use. A Driver only gets here holding its mutex.
+func (authd *authdetails) callContext() *callContext {
+	if authd.calls == nil {
+		authd.calls = newCallContext()
+	}
+	return authd.calls
+}
+
+// callContext returns the driver's call context, creating it under the
+// mutex so concurrent calls share one.
+func (d *Driver) callContext() *callContext {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.authdetails.callContext()
+}
+
+// Machines created before the API timeout option use the default
+func (authd *authdetails) apiTimeout() time.Duration {
+	if authd.APITimeout == 0 {
+		return defaultAPITimeout * time.Second
+	}
+	return time.Duration(authd.APITimeout) * time.Second
+}
+
+// timeoutTransport gives each request its own deadline under the call
+// context, covering the time taken to read the response body too.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	calls   *callContext
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(t.calls.current(), t.timeout)
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return nil, fmt.Errorf("Brightbox API call %s %s timed out after %s", req.Method, req.URL.Path, t.timeout)
+		case context.Canceled:
+			return nil, fmt.Errorf("Brightbox API call %s %s cancelled", req.Method, req.URL.Path)
+		}
+		return nil, err
+	}
+	res.Body = &cancellingBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancellingBody releases the request's deadline once it is closed
+type cancellingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancellingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,107 @@
+package brightbox
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// slowServer answers /slow only once release is closed
+func slowServer(release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+}
+
+func testCallContext() *callContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &callContext{ctx: ctx, cancel: cancel}
+}
+
+func TestTimeoutTransportTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := slowServer(release)
+	defer server.Close()
+	defer close(release)
+	client := &http.Client{
+		Transport: &timeoutTransport{
+			base:    http.DefaultTransport,
+			calls:   testCallContext(),
+			timeout: 20 * time.Millisecond,
+		},
+	}
+	_, err := client.Get(server.URL + "/slow")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Timeout not reported: %v", err)
+	}
+}
+
+func TestTimeoutTransportCancelAndDetach(t *testing.T) {
+	release := make(chan struct{})
+	server := slowServer(release)
+	defer server.Close()
+	defer close(release)
+	calls := testCallContext()
+	client := &http.Client{
+		Transport: &timeoutTransport{
+			base:    http.DefaultTransport,
+			calls:   calls,
+			timeout: time.Minute,
+		},
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		calls.cancel()
+	}()
+	_, err := client.Get(server.URL + "/slow")
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("Cancellation not reported: %v", err)
+	}
+	if !calls.interrupted() {
+		t.Error("Call context not marked as interrupted")
+	}
+	calls.detach()
+	res, err := client.Get(server.URL + "/fast")
+	if err != nil {
+		t.Fatalf("Detached call failed: %s", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("Unexpected response body: %s", body)
+	}
+}
+
+func TestAPITimeoutDefault(t *testing.T) {
+	authd := new(authdetails)
+	if authd.apiTimeout() != defaultAPITimeout*time.Second {
+		t.Errorf("Incorrect default API timeout: %s", authd.apiTimeout())
+	}
+	authd.APITimeout = 5
+	if authd.apiTimeout() != 5*time.Second {
+		t.Errorf("Incorrect API timeout: %s", authd.apiTimeout())
+	}
+}
+
+func TestDriverCallContextShared(t *testing.T) {
+	driver := new(Driver)
+	contexts := make(chan *callContext, 10)
+	for i := 0; i < cap(contexts); i++ {
+		go func() { contexts <- driver.callContext() }()
+	}
+	first := <-contexts
+	for i := 1; i < cap(contexts); i++ {
+		if <-contexts != first {
+			t.Fatal("Concurrent calls created separate call contexts")
+		}
+	}
+	first.cancel()
+}
@@ -1,9 +1,11 @@
 package brightbox
 
 import (
+	"net/http"
 	"os"
 
 	"github.com/brightbox/gobrightbox"
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
@@ -11,29 +13,53 @@ import (
 var infrastructureScope = []string{"infrastructure"}
 
 type authdetails struct {
-	APIClient    string
-	APISecret    string
-	UserName     string
-	password     string
-	Account      string
-	APIURL       string
-	currentToken *oauth2.Token
+	APIClient         string
+	APISecret         string
+	UserName          string
+	password          string
+	otp               string
+	Account           string
+	APIURL            string
+	Trace             bool
+	TraceFile         string
+	SecretsFile       bool
+	CredentialProcess string
+	APITimeout        int
+	apiSecret         string //client secret held outside config.json
+	processCreds      *processCredentials
+	currentToken      *oauth2.Token
+	calls             *callContext
+	tokenCacheDir     string
 }
 
 // Authenticate the details and return a client
 // Region must be in regionURL map.
 func (authd *authdetails) authenticatedClient() (*brightbox.Client, error) {
+	if err := authd.runCredentialProcess(); err != nil {
+		return nil, err
+	}
 	authd.backfillPassword()
+	addSecret(authd.clientSecret())
+	addSecret(authd.userPassword())
 	switch {
-	case authd.currentToken != nil:
-		return authd.tokenisedAuth()
-	case authd.UserName != "" || authd.password != "":
+	case authd.userName() != "" || authd.userPassword() != "":
 		return authd.tokenisedAuth()
 	default:
 		return authd.apiClientAuth()
 	}
 }
 
+// clientSecret returns the API client secret, wherever it is held.
+func (authd *authdetails) clientSecret() string {
+	if authd.processCreds != nil && authd.processCreds.ClientID != "" {
+		return authd.processCreds.ClientSecret
+	}
+	if authd.apiSecret != "" {
+		return authd.apiSecret
+	}
+	return authd.APISecret
+}
+
 func (authd *authdetails) backfillPassword() {
 	if authd.UserName != "" && authd.password == "" {
 		authd.password = os.Getenv(passwordEnvVar)
@@ -44,33 +70,110 @@ func (authd *authdetails) tokenURL() string {
 	return authd.APIURL + "/token"
 }
 
-func (authd *authdetails) tokenisedAuth() (*brightbox.Client, error) {
-	conf := oauth2.Config{
-		ClientID:     authd.APIClient,
-		ClientSecret: authd.APISecret,
+// apiContext returns the context used for token and API requests. It
+// carries an HTTP client that applies the per call timeout and, with
+// tracing on, traces each call.
+func (authd *authdetails) apiContext() (context.Context, error) {
+	var base http.RoundTripper = http.DefaultTransport
+	if authd.Trace {
+		transport := &tracingTransport{base: base}
+		if authd.TraceFile != "" {
+			// Make sure the file can be written before any calls are made
+			if _, err := appendFile(authd.TraceFile).Write(nil); err != nil {
+				return nil, err
+			}
+			transport.dump = appendFile(authd.TraceFile)
+		}
+		base = transport
+	}
+	transport := &timeoutTransport{
+		base:    base,
+		calls:   authd.callContext(),
+		timeout: authd.apiTimeout(),
+	}
+	return context.WithValue(oauth2.NoContext, oauth2.HTTPClient, &http.Client{Transport: transport}), nil
+}
+
+func (authd *authdetails) passwordConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     authd.clientID(),
+		ClientSecret: authd.clientSecret(),
 		Scopes:       infrastructureScope,
 		Endpoint: oauth2.Endpoint{
 			TokenURL: authd.tokenURL(),
 		},
 	}
-	if authd.currentToken == nil {
-		token, err := conf.PasswordCredentialsToken(oauth2.NoContext, authd.UserName, authd.password)
-		if err != nil {
-			return nil, err
-		}
-		authd.currentToken = token
+}
+
+func (authd *authdetails) clientCredentialsConfig() *clientcredentials.Config {
+	return &clientcredentials.Config{
+		ClientID:     authd.clientID(),
+		ClientSecret: authd.clientSecret(),
+		Scopes:       infrastructureScope,
+		TokenURL:     authd.tokenURL(),
+	}
+}
+
+func (authd *authdetails) setToken(token *oauth2.Token) {
+	authd.currentToken = token
+	addSecret(token.AccessToken)
+	addSecret(token.RefreshToken)
+}
+
+func (authd *authdetails) tokenisedAuth() (*brightbox.Client, error) {
+	ctx, err := authd.apiContext()
+	if err != nil {
+		return nil, err
 	}
-	oauthConnection := conf.Client(oauth2.NoContext, authd.currentToken)
+	source := authd.passwordTokenSource(ctx)
+	if _, err := source.Token(); err != nil {
+		return nil, err
+	}
+	oauthConnection := renewingClient(source, contextTransport(ctx))
 	return brightbox.NewClient(authd.APIURL, authd.Account, oauthConnection)
 }
 
 func (authd *authdetails) apiClientAuth() (*brightbox.Client, error) {
-	conf := clientcredentials.Config{
-		ClientID:     authd.APIClient,
-		ClientSecret: authd.APISecret,
-		Scopes:       infrastructureScope,
-		TokenURL:     authd.tokenURL(),
+	ctx, err := authd.apiContext()
+	if err != nil {
+		return nil, err
 	}
-	oauthConnection := conf.Client(oauth2.NoContext)
+	oauthConnection := renewingClient(authd.clientCredentialsTokenSource(ctx), contextTransport(ctx))
 	return brightbox.NewClient(authd.APIURL, authd.Account, oauthConnection)
 }
+
+// The token sources rerun the credential process before authenticating
+// again, in case the credentials it supplied have expired.
+
+func (authd *authdetails) passwordTokenSource(ctx context.Context) *renewingTokenSource {
+	shared := authd.sharedTokenCache()
+	return &renewingTokenSource{
+		token: authd.currentToken,
+		refresh: func(token *oauth2.Token) (*oauth2.Token, error) {
+			expired := &oauth2.Token{RefreshToken: token.RefreshToken}
+			return authd.passwordConfig().TokenSource(ctx, expired).Token()
+		},
+		acquire: func() (*oauth2.Token, error) {
+			if err := authd.runCredentialProcess(); err != nil {
+				return nil, err
+			}
+			return authd.passwordToken(ctx, authd.passwordConfig(), shared)
+		},
+		update: authd.setToken,
+		shared: shared,
+	}
+}
+
+func (authd *authdetails) clientCredentialsTokenSource(ctx context.Context) *renewingTokenSource {
+	return &renewingTokenSource{
+		token: authd.currentToken,
+		acquire: func() (*oauth2.Token, error) {
+			if err := authd.runCredentialProcess(); err != nil {
+				return nil, err
+			}
+			return authd.clientCredentialsConfig().Token(ctx)
+		},
+		update: authd.setToken,
+		shared: authd.sharedTokenCache(),
+	}
+}
@@ -0,0 +1,79 @@
+package brightbox
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authorizedKeys returns the extra public keys to install on the
+// server. Each entry is either a public key or the path of a file of
+// them in authorized_keys format. Every key is checked to be valid.
+func authorizedKeys(entries []string) ([]string, error) {
+	var keys []string
+	for _, entry := range entries {
+		if key, err := parseAuthorizedKey([]byte(entry)); err == nil {
+			keys = append(keys, key)
+			continue
+		}
+		data, err := ioutil.ReadFile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Authorized key %q is neither a public key nor a readable file: %s", entry, err)
+		}
+		found, err := parseAuthorizedKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("Authorized key file %s is invalid: %s", entry, err)
+		}
+		keys = append(keys, found...)
+	}
+	return keys, nil
+}
+
+// parseAuthorizedKeys reads every key in an authorized_keys file,
+// skipping blank lines and comments.
+func parseAuthorizedKeys(data []byte) ([]string, error) {
+	var keys []string
+	for number, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, err := parseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", number+1, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+	return keys, nil
+}
+
+// parseAuthorizedKey checks a single public key, returning it in
+// canonical form with its comment, if any.
+func parseAuthorizedKey(line []byte) (string, error) {
+	key, comment, options, rest, err := ssh.ParseAuthorizedKey(line)
+	switch {
+	case err != nil:
+		return "", err
+	case len(options) > 0:
+		return "", fmt.Errorf("options are not supported")
+	case len(bytes.TrimSpace(rest)) > 0:
+		return "", fmt.Errorf("only one key is allowed")
+	}
+	canonical := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		canonical += " " + comment
+	}
+	return canonical, nil
+}
+
+// yamlQuote writes a key as a single quoted YAML scalar, so a comment
+// containing ": ", "#" or quotes can't change the cloud-config.
+func yamlQuote(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
@@ -0,0 +1,107 @@
+package brightbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestAuthorizedKey(t *testing.T, comment string) string {
+	_, public, err := generateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := string(bytes.TrimSpace(public))
+	if comment != "" {
+		key += " " + comment
+	}
+	return key
+}
+
+func TestAuthorizedKeysLiteral(t *testing.T) {
+	key := newTestAuthorizedKey(t, "ops@example.com")
+	keys, err := authorizedKeys([]string{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Errorf("Key not returned unchanged: %v", keys)
+	}
+}
+
+func TestAuthorizedKeysFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := newTestAuthorizedKey(t, "alice")
+	second := newTestAuthorizedKey(t, "")
+	path := filepath.Join(dir, "team_keys")
+	content := "# Ops team\n" + first + "\n\n" + second + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := authorizedKeys([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != first || keys[1] != second {
+		t.Errorf("Keys not read from file: %v", keys)
+	}
+}
+
+func TestAuthorizedKeysInvalid(t *testing.T) {
+	invalid := []string{
+		"ssh-rsa notakey",
+		`command="/bin/false" ` + newTestAuthorizedKey(t, ""),
+		"/no/such/file",
+	}
+	for _, entry := range invalid {
+		if _, err := authorizedKeys([]string{entry}); err == nil {
+			t.Errorf("Invalid authorized key accepted: %s", entry)
+		}
+	}
+}
+
+func TestAuthorizedKeysValidation(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-authorized-key"] = []string{"ssh-rsa notakey"}
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Invalid authorized key not picked up")
+	}
+}
+
+func TestYAMLQuote(t *testing.T) {
+	cases := map[string]string{
+		"ssh-rsa AAAA ops: emergency access": "'ssh-rsa AAAA ops: emergency access'",
+		"ssh-rsa AAAA fred's key":            "'ssh-rsa AAAA fred''s key'",
+		`ssh-rsa AAAA "quoted" # comment`:    `'ssh-rsa AAAA "quoted" # comment'`,
+	}
+	for value, expected := range cases {
+		if quoted := yamlQuote(value); quoted != expected {
+			t.Errorf("%q quoted as %s", value, quoted)
+		}
+	}
+}
+
+func TestCloudInitAuthorizedKeys(t *testing.T) {
+	driver := new(Driver)
+	key := newTestAuthorizedKey(t, "ops: emergency access")
+	quoted := newTestAuthorizedKey(t, "fred's key # laptop")
+	driver.AuthorizedKeys = []string{key, quoted}
+	userdata := getTestCloudInit(t, driver)
+	if !strings.Contains(userdata, "  - 'ssh-rsa AAAAB3NzaC1yc2E test'\n") {
+		t.Errorf("Machine key missing: %s", userdata)
+	}
+	if !strings.Contains(userdata, "  - '"+key+"'\n") {
+		t.Errorf("Authorized key missing: %s", userdata)
+	}
+	if !strings.Contains(userdata, "  - '"+strings.Replace(quoted, "'", "''", -1)+"'\n") {
+		t.Errorf("Authorized key with quote missing: %s", userdata)
+	}
+}
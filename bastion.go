@@ -0,0 +1,103 @@
+package brightbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnutils"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultBastionPort = 22
+	bastionKeyFile     = "bastion_key"
+	bastionHostKeyFile = "bastion_host_key"
+)
+
+// parseBastion splits a bastion specification of the form
+// user@host[:port] into its parts.
+func parseBastion(spec string) (user string, address string, err error) {
+	at := strings.LastIndex(spec, "@")
+	if at < 1 || at == len(spec)-1 {
+		return "", "", fmt.Errorf("Bastion %q must be given as user@host[:port]", spec)
+	}
+	user, host := spec[:at], spec[at+1:]
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return user, host, nil
+	}
+	return user, net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(defaultBastionPort)), nil
+}
+
+func (d *Driver) bastionKeyPath() string {
+	return d.ResolveStorePath(bastionKeyFile)
+}
+
+// defaultBastionKey is the user's own SSH key, which the bastion is
+// most likely to accept.
+func defaultBastionKey() string {
+	return filepath.Join(mcnutils.GetHomeDir(), ".ssh", "id_rsa")
+}
+
+// Copy the bastion key into the machine directory so the machine
+// doesn't depend on the original staying in place.
+func (d *Driver) copyBastionKey() error {
+	if d.Bastion == "" {
+		return nil
+	}
+	log.Debugf("Copying bastion SSH key %s", d.BastionKey)
+	return mcnutils.CopyFile(d.BastionKey, d.bastionKeyPath())
+}
+
+// dialBastion connects to the bastion given as user@host[:port]. Its
+// host key is recorded in hostKeyPath the first time and checked after
+// that.
+func dialBastion(bastion, keyPath, hostKeyPath string) (*ssh.Client, error) {
+	user, address, err := parseBastion(bastion)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read bastion SSH key: %s", err)
+	}
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: pinnedHostKey(hostKeyPath),
+	}
+	log.Debugf("Connecting to bastion %s", address)
+	client, err := ssh.Dial("tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to bastion %s: %s", address, err)
+	}
+	return client, nil
+}
+
+// pinnedHostKey accepts the first host key it sees, records it in path
+// and rejects any different key after that.
+func pinnedHostKey(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := ssh.MarshalAuthorizedKey(key)
+		pinned, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			log.Debugf("Recording SSH host key for %s in %s", hostname, path)
+			return ioutil.WriteFile(path, presented, 0644)
+		case err != nil:
+			return err
+		case string(pinned) != string(presented):
+			return fmt.Errorf("SSH host key for %s does not match the key recorded in %s", hostname, path)
+		}
+		return nil
+	}
+}
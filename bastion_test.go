@@ -0,0 +1,69 @@
+package brightbox
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseBastion(t *testing.T) {
+	tests := []struct {
+		spec, user, address string
+	}{
+		{"jump@bastion.example.com", "jump", "bastion.example.com:22"},
+		{"jump@bastion.example.com:2222", "jump", "bastion.example.com:2222"},
+		{"jump@[2a02:1348::1]:2222", "jump", "[2a02:1348::1]:2222"},
+		{"jump@[2a02:1348::1]", "jump", "[2a02:1348::1]:22"},
+	}
+	for _, test := range tests {
+		user, address, err := parseBastion(test.spec)
+		if err != nil {
+			t.Errorf("Bastion %s rejected: %s", test.spec, err)
+			continue
+		}
+		if user != test.user || address != test.address {
+			t.Errorf("Bastion %s parsed as %s and %s", test.spec, user, address)
+		}
+	}
+	for _, spec := range []string{"bastion.example.com", "@bastion.example.com", "jump@"} {
+		if _, _, err := parseBastion(spec); err == nil {
+			t.Errorf("Invalid bastion %s accepted", spec)
+		}
+	}
+}
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public
+}
+
+func TestPinnedHostKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	callback := pinnedHostKey(filepath.Join(dir, "host_key"))
+	first := newTestHostKey(t)
+	if err := callback("bastion:22", nil, first); err != nil {
+		t.Fatalf("First host key rejected: %s", err)
+	}
+	if err := callback("bastion:22", nil, first); err != nil {
+		t.Errorf("Pinned host key rejected: %s", err)
+	}
+	if err := callback("bastion:22", nil, newTestHostKey(t)); err == nil {
+		t.Error("Changed host key accepted")
+	}
+}
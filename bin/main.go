@@ -1,10 +1,71 @@
 package main
 
 import (
-	"github.com/docker/machine/libmachine/drivers/plugin"
+	"fmt"
+	"os"
+	"path/filepath"
+
 	"github.com/brightbox/docker-machine-driver-brightbox"
+	"github.com/docker/machine/libmachine/drivers/plugin"
+	"github.com/docker/machine/libmachine/mcnutils"
 )
 
 func main() {
+	if len(os.Args) == 3 && os.Args[1] == "console" {
+		os.Exit(showConsole(os.Args[2]))
+	}
+	if len(os.Args) == 3 && os.Args[1] == "labels" {
+		os.Exit(showLabels(os.Args[2]))
+	}
+	if len(os.Args) == 2 && os.Args[1] == "tunnel" {
+		if err := brightbox.RunTunnel(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	plugin.RegisterDriver(new(brightbox.Driver))
 }
+
+// loadMachine reads a machine from the docker-machine store
+func loadMachine(name string) (*brightbox.Driver, error) {
+	storePath := os.Getenv("MACHINE_STORAGE_PATH")
+	if storePath == "" {
+		storePath = filepath.Join(mcnutils.GetHomeDir(), ".docker", "machine")
+	}
+	return brightbox.LoadMachine(storePath, name)
+}
+
+// showConsole prints the console details of a machine
+func showConsole(name string) int {
+	driver, err := loadMachine(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	details, err := driver.Console()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(details)
+	return 0
+}
+
+// showLabels prints the labels of a machine's server, one per line
+func showLabels(name string) int {
+	driver, err := loadMachine(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	labels, err := driver.ServerLabels()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, label := range labels {
+		fmt.Println(label)
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package brightbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Console activates the server's web console and returns how to reach
+// it. The Brightbox API gives access to the console but not its output,
+// so that has to be read in a browser.
+func (d *Driver) Console() (string, error) {
+	server, err := d.activateConsole()
+	if err != nil {
+		return "", err
+	}
+	return consoleDetails(d.MachineID, server), nil
+}
+
+func (d *Driver) activateConsole() (*brightbox.Server, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Brightbox API Call: Activate Console for Server %s", d.MachineID)
+	server, err := client.ActivateConsoleForServer(d.MachineID)
+	if err != nil {
+		return nil, fmt.Errorf("Activating the console of server %s failed: %s", d.MachineID, err)
+	}
+	addSecret(server.ConsoleToken)
+	return server, nil
+}
+
+func consoleDetails(machineID string, server *brightbox.Server) string {
+	var details bytes.Buffer
+	fmt.Fprintf(&details, "Console for server %s: %s\n", machineID, server.ConsoleUrl)
+	fmt.Fprintf(&details, "Password: %s", server.ConsoleToken)
+	details.WriteString(consoleExpiry(server))
+	details.WriteString("\nOpen the console in a browser to see its output.")
+	return details.String()
+}
+
+func consoleExpiry(server *brightbox.Server) string {
+	if server.ConsoleTokenExpires == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (expires %s)", server.ConsoleTokenExpires.Local().Format(time.RFC1123))
+}
+
+// logConsole activates the console of a server that failed to come
+// up, so the boot can be examined before the server is removed, and
+// says where to find it. The console password is never logged: the
+// console command prints it to stdout when asked.
+func (d *Driver) logConsole() {
+	server, err := d.activateConsole()
+	if err != nil {
+		log.Warnf("%s", err)
+	} else {
+		log.Warnf("Console for server %s: %s%s", d.MachineID, server.ConsoleUrl, consoleExpiry(server))
+	}
+	log.Warnf("To see the console password, run: docker-machine-driver-brightbox console %s",
+		d.GetMachineName())
+}
+
+// LoadMachine reads the driver settings of a machine from its
+// config.json in the docker-machine store.
+func LoadMachine(storePath, name string) (*Driver, error) {
+	path := filepath.Join(storePath, "machines", name, "config.json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		DriverName string
+		Driver     *Driver
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", path, err)
+	}
+	if config.DriverName != driverName || config.Driver == nil {
+		return nil, fmt.Errorf("Machine %s does not use the %s driver", name, driverName)
+	}
+	return config.Driver, nil
+}
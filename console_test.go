@@ -0,0 +1,52 @@
+package brightbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+func TestConsoleDetails(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	server := new(brightbox.Server)
+	server.ConsoleUrl = "https://srv-testy.console.gb1.brightbox.com"
+	server.ConsoleToken = "tokentoken"
+	server.ConsoleTokenExpires = &expires
+	details := consoleDetails("srv-testy", server)
+	for _, expected := range []string{"srv-testy", server.ConsoleUrl, server.ConsoleToken, "expires"} {
+		if !strings.Contains(details, expected) {
+			t.Errorf("Console details missing %q: %s", expected, details)
+		}
+	}
+}
+
+func TestLoadMachine(t *testing.T) {
+	store, err := ioutil.TempDir("", "brightbox-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+	dir := filepath.Join(store, "machines", "testy")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"DriverName": "brightbox", "Driver": {"MachineID": "srv-testy", "APIClient": "cli-12345"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	driver, err := LoadMachine(store, "testy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.MachineID != "srv-testy" || driver.APIClient != "cli-12345" {
+		t.Errorf("Machine settings not loaded: %s, %s", driver.MachineID, driver.APIClient)
+	}
+	if _, err := LoadMachine(store, "missing"); err == nil {
+		t.Error("Missing machine not detected")
+	}
+}
@@ -0,0 +1,104 @@
+package brightbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const credentialProcessVersion = 1
+
+// processCredentials is the JSON a credential process writes to stdout
+type processCredentials struct {
+	Version      int
+	ClientID     string `json:"ClientId"`
+	ClientSecret string
+	UserName     string
+	Password     string
+	Expiration   *time.Time
+}
+
+// runCredentialProcess fetches the credentials from the external
+// credential process, if there is one. They are held in memory only,
+// so they never reach config.json or the secrets file, and are reused
+// until they expire.
+func (authd *authdetails) runCredentialProcess() error {
+	if authd.CredentialProcess == "" {
+		return nil
+	}
+	if authd.processCreds != nil && !authd.processCreds.expired() {
+		log.Debug("Reusing credentials from credential process")
+		return nil
+	}
+	log.Debugf("Running credential process %s", authd.CredentialProcess)
+	creds, err := readCredentialProcess(authd.CredentialProcess)
+	if err != nil {
+		return err
+	}
+	addSecret(creds.ClientSecret)
+	addSecret(creds.Password)
+	authd.processCreds = creds
+	return nil
+}
+
+// clientID returns the API client, preferring one from the credential
+// process.
+func (authd *authdetails) clientID() string {
+	if authd.processCreds != nil && authd.processCreds.ClientID != "" {
+		return authd.processCreds.ClientID
+	}
+	return authd.APIClient
+}
+
+// userName returns the user, preferring one from the credential process
+func (authd *authdetails) userName() string {
+	if authd.processCreds != nil && authd.processCreds.UserName != "" {
+		return authd.processCreds.UserName
+	}
+	return authd.UserName
+}
+
+// userPassword returns the password that goes with userName
+func (authd *authdetails) userPassword() string {
+	if authd.processCreds != nil && authd.processCreds.UserName != "" {
+		return authd.processCreds.Password
+	}
+	return authd.password
+}
+
+// Credentials without an expiry time last for the life of the plugin
+func (creds *processCredentials) expired() bool {
+	return creds.Expiration != nil && !time.Now().Before(*creds.Expiration)
+}
+
+func readCredentialProcess(command string) (*processCredentials, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Credential process failed: %s", err)
+	}
+	var creds processCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("Unable to read output of credential process: %s", err)
+	}
+	switch {
+	case creds.Version != credentialProcessVersion:
+		return nil, fmt.Errorf("Credential process returned unsupported version %d", creds.Version)
+	case creds.ClientID == "" && creds.UserName == "":
+		return nil, fmt.Errorf("Credential process returned neither a ClientId nor a UserName")
+	}
+	return &creds, nil
+}
@@ -0,0 +1,69 @@
+package brightbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCredentialProcess(t *testing.T) {
+	authd := &authdetails{
+		CredentialProcess: `echo '{"Version": 1, "ClientId": "cli-12345", "ClientSecret": "abcdefg"}'`,
+	}
+	if err := authd.runCredentialProcess(); err != nil {
+		t.Fatal(err)
+	}
+	if authd.clientID() != "cli-12345" || authd.clientSecret() != "abcdefg" {
+		t.Errorf("Client credentials not set: %s %s", authd.clientID(), authd.clientSecret())
+	}
+	authd.CredentialProcess = "exit 1"
+	if err := authd.runCredentialProcess(); err != nil {
+		t.Errorf("Cached credentials not reused: %s", err)
+	}
+}
+
+func TestCredentialProcessUser(t *testing.T) {
+	authd := &authdetails{
+		APIClient:         defaultClientID,
+		CredentialProcess: `echo '{"Version": 1, "UserName": "fred@example.com", "Password": "hunter2"}'`,
+	}
+	if err := authd.runCredentialProcess(); err != nil {
+		t.Fatal(err)
+	}
+	if authd.userName() != "fred@example.com" || authd.userPassword() != "hunter2" {
+		t.Errorf("User credentials not set: %s %s", authd.userName(), authd.userPassword())
+	}
+	if authd.clientID() != defaultClientID {
+		t.Errorf("Client changed: %s", authd.clientID())
+	}
+}
+
+func TestCredentialProcessExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	creds := &processCredentials{Expiration: &past}
+	if !creds.expired() {
+		t.Error("Expired credentials not detected")
+	}
+	future := time.Now().Add(time.Hour)
+	creds.Expiration = &future
+	if creds.expired() {
+		t.Error("Current credentials treated as expired")
+	}
+	creds.Expiration = nil
+	if creds.expired() {
+		t.Error("Credentials without expiry treated as expired")
+	}
+}
+
+func TestCredentialProcessErrors(t *testing.T) {
+	commands := []string{
+		"exit 1",
+		"echo not json",
+		`echo '{"Version": 2, "ClientId": "cli-12345"}'`,
+		`echo '{"Version": 1}'`,
+	}
+	for _, command := range commands {
+		if _, err := readCredentialProcess(command); err == nil {
+			t.Errorf("Bad credential process %q accepted", command)
+		}
+	}
+}
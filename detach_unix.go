@@ -0,0 +1,23 @@
+// +build !windows
+
+package brightbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcess puts the forwarder in its own process group, so an
+// interrupt aimed at docker-machine doesn't stop it too.
+func detachedProcess() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// processAlive checks for a running process with the given pid
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
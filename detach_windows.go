@@ -0,0 +1,26 @@
+package brightbox
+
+import "syscall"
+
+// The exit code of a process that hasn't exited
+const stillActive = 259
+
+// detachedProcess puts the forwarder in its own process group, so an
+// interrupt aimed at docker-machine doesn't stop it too.
+func detachedProcess() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// processAlive checks for a running process with the given pid
+func processAlive(pid int) bool {
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -2,15 +2,22 @@
 package brightbox
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/brightbox/gobrightbox"
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/mcnutils"
 	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/state"
 )
@@ -25,6 +32,11 @@ const (
 	defaultIPV6       = true
 	defaultServerType = "1gb.ssd"
 
+	// Seconds to wait for a server to shut down before stopping it
+	defaultStopTimeout = 120
+
+	dockerPort = 2376
+
 	driverName     = "brightbox"
 	passwordEnvVar = "BRIGHTBOX_PASSWORD"
 )
@@ -35,10 +47,30 @@ type Driver struct {
 	drivers.BaseDriver
 	authdetails
 	brightbox.ServerOptions
-	MachineID    string
-	IPv6         bool
-	mu           sync.Mutex //guards activeClient
-	activeClient *brightbox.Client
+	MachineID         string
+	IPv6              bool
+	AddressMode       string
+	UseIPAddress      bool
+	SSHKey            string
+	StopTimeout       int
+	DryRun            bool
+	Labels            []string
+	AuthorizedKeys    []string
+	Domain            string
+	PriceList         string
+	MaxHourlyCost     float64
+	Bastion           string
+	BastionKey        string
+	StrictHostKey     bool
+	TunnelSSHPort     int
+	TunnelDockerPort  int
+	HostKeyPinned     bool
+	mu                sync.Mutex //guards activeClient
+	activeClient      *brightbox.Client
+	liveDetails       bool
+	probedAddressMode string //result of auto address mode for this run
+	hostKey           []byte
+	hostKeyPublic     []byte
 }
 
 //NewDriver is a backward compatible Driver factory method.  Using
@@ -66,6 +98,16 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Brightbox Cloud API Client Secret",
 			Value:  defaultClientSecret,
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "BRIGHTBOX_SECRETS_FILE",
+			Name:   "brightbox-secrets-file",
+			Usage:  "Keep the API Client Secret in a file only you can read rather than the machine's config.json",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_CREDENTIAL_PROCESS",
+			Name:   "brightbox-credential-process",
+			Usage:  "Command that writes Brightbox Cloud credentials to stdout as JSON",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "BRIGHTBOX_USER_NAME",
 			Name:   "brightbox-user-name",
@@ -76,10 +118,15 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "brightbox-password",
 			Usage:  "Brightbox Cloud Password for User Name",
 		},
+		mcnflag.StringFlag{
+			EnvVar: otpEnvVar,
+			Name:   "brightbox-otp",
+			Usage:  "Brightbox Cloud two factor authentication code for User Name",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "BRIGHTBOX_ACCOUNT",
 			Name:   "brightbox-account",
-			Usage:  "Brightbox Cloud Account to operate on",
+			Usage:  "Brightbox Cloud Account ID or name to operate on",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "BRIGHTBOX_API_URL",
@@ -87,11 +134,52 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Brightbox Cloud Api URL for selected Region",
 			Value:  brightbox.DefaultRegionApiURL,
 		},
+		mcnflag.IntFlag{
+			EnvVar: "BRIGHTBOX_API_TIMEOUT",
+			Name:   "brightbox-api-timeout",
+			Usage:  "Seconds to wait for each Brightbox Cloud API call",
+			Value:  defaultAPITimeout,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "BRIGHTBOX_TRACE",
+			Name:   "brightbox-trace",
+			Usage:  "Log the method, path, status, latency and request ID of each Brightbox Cloud API call",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_TRACE_FILE",
+			Name:   "brightbox-trace-file",
+			Usage:  "File to append redacted API requests and responses to. Implies --brightbox-trace",
+		},
 		mcnflag.BoolFlag{
 			EnvVar: "BRIGHTBOX_IPV4",
 			Name:   "brightbox-ipv4",
 			Usage:  "Access server over IPv4 rather than IPv6",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_ADDRESS_MODE",
+			Name:   "brightbox-address-mode",
+			Usage:  "Access server over ipv6, ipv4, private or whichever is reachable (auto). Overrides --brightbox-ipv4",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_BASTION",
+			Name:   "brightbox-bastion",
+			Usage:  "SSH jump host, as user@host[:port], to reach the server through",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_BASTION_KEY",
+			Name:   "brightbox-bastion-key",
+			Usage:  "Path to the SSH private key for the bastion. Defaults to ~/.ssh/id_rsa",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "BRIGHTBOX_STRICT_HOST_KEY",
+			Name:   "brightbox-strict-host-key",
+			Usage:  "Send SSH through a local forwarder that checks the server's pinned host key",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "BRIGHTBOX_USE_IP_ADDRESS",
+			Name:   "brightbox-use-ip-address",
+			Usage:  "Access server by IP address rather than DNS name",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "BRIGHTBOX_ZONE",
 			Name:   "brightbox-zone",
@@ -107,12 +195,64 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "brightbox-group",
 			Usage:  "Brightbox Cloud Security Group",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "BRIGHTBOX_AUTHORIZED_KEY",
+			Name:   "brightbox-authorized-key",
+			Usage:  "Additional SSH public key, or file of keys, to authorize on the server. Can be repeated",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_DOMAIN",
+			Name:   "brightbox-domain",
+			Usage:  "Domain to add to the machine name to make the server's fully qualified domain name",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "BRIGHTBOX_LABEL",
+			Name:   "brightbox-label",
+			Usage:  "Label, as key=value, to add to the server name. Can be repeated",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "BRIGHTBOX_TYPE",
 			Name:   "brightbox-type",
 			Usage:  "Brightbox Cloud Server Type",
 			Value:  defaultServerType,
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "BRIGHTBOX_DRY_RUN",
+			Name:   "brightbox-dry-run",
+			Usage:  "Check everything and print the server creation request without creating the server",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_PRICE_LIST",
+			Name:   "brightbox-price-list",
+			Usage:  "JSON file of hourly prices by server type handle",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_MAX_HOURLY_COST",
+			Name:   "brightbox-max-hourly-cost",
+			Usage:  "Refuse to create a server that costs more than this per hour. Requires --brightbox-price-list",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "BRIGHTBOX_STOP_TIMEOUT",
+			Name:   "brightbox-stop-timeout",
+			Usage:  "Seconds to wait for the server to shut down before stopping it hard",
+			Value:  defaultStopTimeout,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_SSH_KEY",
+			Name:   "brightbox-ssh-key",
+			Usage:  "Path to an existing SSH private key to use instead of generating one",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "BRIGHTBOX_SSH_PORT",
+			Name:   "brightbox-ssh-port",
+			Usage:  "SSH port the server should listen on",
+			Value:  defaultSSHPort,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "BRIGHTBOX_SSH_USER",
+			Name:   "brightbox-ssh-user",
+			Usage:  "SSH Username to use instead of the one set by the image",
+		},
 	}
 }
 
@@ -123,24 +263,66 @@ func (d *Driver) DriverName() string {
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.APIClient = flags.String("brightbox-client")
 	d.APISecret = flags.String("brightbox-client-secret")
+	d.SecretsFile = flags.Bool("brightbox-secrets-file")
+	if d.SecretsFile {
+		d.apiSecret, d.APISecret = d.APISecret, ""
+	}
 	d.UserName = flags.String("brightbox-user-name")
+	d.CredentialProcess = flags.String("brightbox-credential-process")
 	d.password = flags.String("brightbox-password")
+	d.otp = flags.String("brightbox-otp")
 	d.Account = flags.String("brightbox-account")
 	d.Image = flags.String("brightbox-image")
 	d.APIURL = flags.String("brightbox-api-url")
+	d.APITimeout = flags.Int("brightbox-api-timeout")
+	d.TraceFile = flags.String("brightbox-trace-file")
+	d.Trace = flags.Bool("brightbox-trace") || d.TraceFile != ""
 	d.ServerType = flags.String("brightbox-type")
+	d.DryRun = flags.Bool("brightbox-dry-run")
+	d.PriceList = flags.String("brightbox-price-list")
+	if maxCost := flags.String("brightbox-max-hourly-cost"); maxCost != "" {
+		cost, err := strconv.ParseFloat(maxCost, 64)
+		if err != nil || cost <= 0 {
+			return fmt.Errorf("Maximum hourly cost must be a positive number, not %s", maxCost)
+		}
+		d.MaxHourlyCost = cost
+	}
 	d.IPv6 = !flags.Bool("brightbox-ipv4")
+	d.AddressMode = flags.String("brightbox-address-mode")
+	d.UseIPAddress = flags.Bool("brightbox-use-ip-address")
+	d.Bastion = flags.String("brightbox-bastion")
+	d.BastionKey = flags.String("brightbox-bastion-key")
+	if d.Bastion != "" && d.BastionKey == "" {
+		d.BastionKey = defaultBastionKey()
+	}
+	d.StrictHostKey = flags.Bool("brightbox-strict-host-key")
 	groupList := flags.StringSlice("brightbox-group")
 	if groupList != nil {
 		d.ServerGroups = &groupList
 	}
 	d.Zone = flags.String("brightbox-zone")
-	d.SSHPort = defaultSSHPort
-	serverName := d.GetMachineName() + " (docker-machine)"
-	d.Name = &serverName
+	d.SSHPort = flags.Int("brightbox-ssh-port")
+	d.StopTimeout = flags.Int("brightbox-stop-timeout")
+	d.SSHKey = flags.String("brightbox-ssh-key")
+	d.SSHUser = flags.String("brightbox-ssh-user")
+	d.Labels = flags.StringSlice("brightbox-label")
+	d.AuthorizedKeys = flags.StringSlice("brightbox-authorized-key")
+	d.Domain = normaliseDomain(flags.String("brightbox-domain"))
+	name := serverName(d.GetMachineName(), d.Labels)
+	d.Name = &name
 	return d.checkConfig()
 }
 
+// String prints the driver configuration with secrets masked, so it is
+// safe to use in debug output.
+func (d *Driver) String() string {
+	config, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Sprintf("Driver %s", d.GetMachineName())
+	}
+	return string(redactBody(config))
+}
+
 // Use a mutex to try and avoid authenticating more than once - even
 // with parallel calls. Cache the authenticated api client in the driver
 // for future use within this run.
@@ -151,13 +333,19 @@ func (d *Driver) getClient() (*brightbox.Client, error) {
 		log.Debug("Reusing authenticated Brightbox client")
 		return d.activeClient, nil
 	}
+	if err := d.loadSecrets(); err != nil {
+		return nil, err
+	}
+	if !d.DryRun {
+		d.tokenCacheDir = d.StorePath
+	}
 	log.Debug("Authenticating Credentials against Brightbox API")
 	client, err := d.authenticatedClient()
 	if err != nil {
 		return nil, err
 	}
 	d.activeClient = client
-	if client.AccountId == "" {
+	if !isAccountID(d.Account) {
 		if err := d.setDefaultAccount(); err != nil {
 			return nil, err
 		}
@@ -174,6 +362,8 @@ const (
 //Statically sanity check flag settings.
 func (d *Driver) checkConfig() error {
 	switch {
+	case d.CredentialProcess != "":
+		// Credentials are only known at authentication time
 	case d.UserName != "" || d.password != "":
 		switch {
 		case d.UserName == "":
@@ -184,6 +374,38 @@ func (d *Driver) checkConfig() error {
 	case d.APIClient == defaultClientID:
 		return fmt.Errorf(errorMandatoryEnvOrOption, "API Client", "BRIGHTBOX_CLIENT", "--brightbox-client")
 	}
+	if err := checkLabels(d.Labels); err != nil {
+		return err
+	}
+	if _, err := authorizedKeys(d.AuthorizedKeys); err != nil {
+		return err
+	}
+	if err := checkDomain(d.Domain); err != nil {
+		return err
+	}
+	if d.MaxHourlyCost > 0 && d.PriceList == "" {
+		return fmt.Errorf(errorMandatoryEnvOrOption, "Price list", "BRIGHTBOX_PRICE_LIST", "--brightbox-price-list")
+	}
+	if !validAddressMode(d.AddressMode) {
+		return fmt.Errorf("Address mode must be one of ipv6, ipv4, private or auto, not %s", d.AddressMode)
+	}
+	if d.Bastion != "" {
+		if _, _, err := parseBastion(d.Bastion); err != nil {
+			return err
+		}
+		if _, err := os.Stat(d.BastionKey); err != nil {
+			return fmt.Errorf("Bastion SSH key unavailable, use --brightbox-bastion-key to choose one: %s", err)
+		}
+	}
+	if d.APITimeout < 1 {
+		return fmt.Errorf("API timeout must be at least 1 second, not %d", d.APITimeout)
+	}
+	if d.StopTimeout < 1 {
+		return fmt.Errorf("Stop timeout must be at least 1 second, not %d", d.StopTimeout)
+	}
+	if d.SSHPort < 1 || d.SSHPort > 65535 {
+		return fmt.Errorf("SSH port %d is out of range", d.SSHPort)
+	}
 	return nil
 }
 
@@ -223,43 +445,119 @@ func (d *Driver) checkImage() error {
 	return nil
 }
 
+// Works out the account ID when the account is given by name or not
+// at all. If that is ambiguous the user is asked to choose on the
+// terminal, if there is one.
 func (d *Driver) setDefaultAccount() error {
 	log.Debug("Looking for default account")
 	client := d.activeClient
+	client.AccountId = ""
 	log.Debug("Brightbox API Call: List of Accounts")
 	accounts, err := client.Accounts()
-	switch {
-	case err != nil:
+	if err != nil {
 		return err
-	case len(accounts) == 1:
-		log.Debug("Setting default account")
-		d.Account = accounts[0].Id
-		client.AccountId = d.Account
-		return nil
+	}
+	candidates := accounts
+	if d.Account != "" {
+		log.Debugf("Looking for account named %s", d.Account)
+		candidates = accountsNamed(accounts, d.Account)
+	}
+	var account *brightbox.Account
+	switch len(candidates) {
+	case 0:
+		return fmt.Errorf("No account named %s. Accessible accounts are:\n%s", d.Account, formatAccounts(accounts))
+	case 1:
+		account = &candidates[0]
 	default:
-		return fmt.Errorf(errorMandatoryEnvOrOption, "Account", "BRIGHTBOX_ACCOUNT", "--brightbox-account")
+		account, err = promptAccount(candidates)
+		switch {
+		case err == errNoTerminal:
+			return fmt.Errorf(errorMandatoryEnvOrOption+". Accessible accounts are:\n%s",
+				"Account", "BRIGHTBOX_ACCOUNT", "--brightbox-account", formatAccounts(candidates))
+		case err != nil:
+			return err
+		}
 	}
+	log.Debugf("Setting account to %s", account.Id)
+	d.Account = account.Id
+	client.AccountId = d.Account
+	return nil
 }
 
-// PreCreateCheck makes sure that the image details are complete
+// PreCreateCheck makes sure that the image details are complete and
+// the server will fit on the account
 func (d *Driver) PreCreateCheck() error {
-	return d.checkImage()
+	if err := d.checkImage(); err != nil {
+		return err
+	}
+	if err := d.checkServerType(); err != nil {
+		return err
+	}
+	if err := d.checkPlacement(); err != nil {
+		return err
+	}
+	// docker-machine saves the machine after this check, so a dry run
+	// has to stop here to leave nothing behind.
+	if d.DryRun {
+		return d.dryRun()
+	}
+	return nil
 }
 
 func (d *Driver) createSSHkey() error {
-	return ssh.GenerateSSHKey(d.GetSSHKeyPath())
+	if d.SSHKey == "" {
+		return ssh.GenerateSSHKey(d.GetSSHKeyPath())
+	}
+	log.Debugf("Copying SSH key %s", d.SSHKey)
+	if err := mcnutils.CopyFile(d.SSHKey, d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publickey, err := readPublicKey(d.SSHKey)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.publicSSHKeyPath(), publickey, 0644)
 }
 
-func (d *Driver) getCloudInit() ([]byte, error) {
-
-	data := []byte(`#cloud-config
-ssh_authorized_keys:
-  - `)
-	publickey, err := ioutil.ReadFile(d.publicSSHKeyPath())
+// Moves sshd to a non-standard port before docker-machine connects.
+// bootcmd handles cloud-init based images, the sshd.socket unit handles
+// CoreOS. Each ignores the section meant for the other.
+const sshPortCloudInit = `bootcmd:
+  - sed -i -e '/^Port /d' -e '$aPort %[1]d' /etc/ssh/sshd_config
+coreos:
+  units:
+    - name: sshd.socket
+      command: restart
+      content: |
+        [Socket]
+        ListenStream=%[1]d
+        FreeBind=true
+        Accept=yes
+`
+
+func (d *Driver) getCloudInit(publickey []byte) ([]byte, error) {
+	extraKeys, err := authorizedKeys(d.AuthorizedKeys)
 	if err != nil {
 		return nil, err
 	}
-	return append(data, publickey...), nil
+	var data bytes.Buffer
+	data.WriteString("#cloud-config\nssh_authorized_keys:\n")
+	for _, key := range append([]string{string(bytes.TrimSpace(publickey))}, extraKeys...) {
+		fmt.Fprintf(&data, "  - %s\n", yamlQuote(key))
+	}
+	if hostname := hostnameLabel(d.GetMachineName()); hostname != "" {
+		fmt.Fprintf(&data, "hostname: %s\n", hostname)
+		if d.Domain != "" {
+			fmt.Fprintf(&data, "fqdn: %s.%s\n", hostname, d.Domain)
+		}
+	}
+	if d.SSHPort != defaultSSHPort {
+		fmt.Fprintf(&data, sshPortCloudInit, d.SSHPort)
+	}
+	if err := d.writeHostKeyCloudInit(&data); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
 }
 
 func (d *Driver) Create() error {
@@ -267,34 +565,136 @@ func (d *Driver) Create() error {
 	if err != nil {
 		return err
 	}
+	if err := d.saveSecrets(); err != nil {
+		return err
+	}
 	log.Infof("Creating SSH key...")
 	err = d.createSSHkey()
 	if err != nil {
 		return err
 	}
-	userdata, err := d.getCloudInit()
+	if err := d.createHostKey(); err != nil {
+		return err
+	}
+	if err := d.pinHostKey(); err != nil {
+		return err
+	}
+	d.HostKeyPinned = true
+	if err := d.copyBastionKey(); err != nil {
+		return err
+	}
+	if err := d.allocateTunnelPorts(); err != nil {
+		return err
+	}
+	publickey, err := ioutil.ReadFile(d.publicSSHKeyPath())
 	if err != nil {
 		return err
 	}
+	userdata, err := d.getCloudInit(publickey)
+	if err != nil {
+		return err
+	}
+	// The user data holds the private host key, so it only goes in the
+	// request, never in the options saved with the machine.
 	encoded := base64.StdEncoding.EncodeToString(userdata)
-	d.UserData = &encoded
+	options := d.ServerOptions
+	options.UserData = &encoded
 	log.Infof("Creating Brightbox Server...")
 	log.Debugf("with the following Userdata")
-	log.Debugf("%s", string(userdata))
+	log.Debugf("%s", redactText(string(userdata)))
 	log.Debugf("Brightbox API Call: Create Server using image %s", d.Image)
-	server, err := client.CreateServer(&d.ServerOptions)
+	d.liveDetails = true
+	requested := time.Now()
+	server, err := client.CreateServer(&options)
+	d.hostKey = nil
 	if err != nil {
+		if d.callContext().interrupted() {
+			return d.rollbackCreate(err, requested)
+		}
 		return err
 	}
 	d.MachineID = server.Id
+	defer d.invalidateServerCache()
+	err = d.waitForState(state.Running, createTimeout)
+	if err == nil {
+		err = d.waitForSSH("", createTimeout)
+	}
+	if err != nil {
+		err = fmt.Errorf("Waiting for server %s to start failed: %s", d.MachineID, err)
+		if d.callContext().interrupted() {
+			return d.rollbackCreate(err, requested)
+		}
+		d.logConsole()
+		return fmt.Errorf("%s. The server has been kept for debugging. Run docker-machine rm to remove it", err)
+	}
 	return nil
 }
 
+// rollbackCreate destroys a server whose creation was cancelled, and
+// returns the original failure. If the request was cancelled before
+// the server ID came back, servers with the machine's name created
+// since the request are destroyed instead. Calls made here are
+// detached from the interrupt so the clean up still runs.
+func (d *Driver) rollbackCreate(cause error, requested time.Time) error {
+	log.Warnf("%s. Removing the server", cause)
+	d.callContext().detach()
+	client, err := d.getClient()
+	if err != nil {
+		return fmt.Errorf("%s. Removal failed: %s", cause, err)
+	}
+	ids := []string{d.MachineID}
+	if d.MachineID == "" {
+		log.Debug("Brightbox API Call: List Servers")
+		servers, err := client.Servers()
+		if err != nil {
+			return fmt.Errorf("%s. Unable to find the server %q to remove it: %s", cause, *d.Name, err)
+		}
+		ids = createdServers(servers, *d.Name, requested)
+	}
+	for _, id := range ids {
+		log.Debugf("Brightbox API Call: Destroy Server %s", id)
+		if err := client.DestroyServer(id); err != nil && !isNotFound(err) {
+			return fmt.Errorf("%s. Removal of server %s failed, remove it from the Brightbox Manager: %s", cause, id, err)
+		}
+	}
+	d.MachineID = ""
+	return cause
+}
+
+// Allows for the API's clock being behind ours when matching servers
+// to a create request.
+const createClockSkew = time.Minute
+
+// createdServers returns the IDs of the live servers called name that
+// were created no earlier than requested.
+func createdServers(servers []brightbox.Server, name string, requested time.Time) []string {
+	var ids []string
+	for _, server := range servers {
+		switch {
+		case server.Name != name:
+		case server.Status == "deleting" || server.Status == "deleted":
+		case server.CreatedAt != nil && server.CreatedAt.Before(requested.Add(-createClockSkew)):
+		default:
+			ids = append(ids, server.Id)
+		}
+	}
+	return ids
+}
+
 func (d *Driver) getServerDetails() (*brightbox.Server, error) {
 	client, err := d.getClient()
 	if err != nil {
 		return nil, err
 	}
+	if cache := d.sharedServerCache(); cache != nil && !d.liveDetails {
+		server, err := cache.server(d.MachineID, func() ([]brightbox.Server, error) {
+			log.Debug("Brightbox API Call: List Servers")
+			return client.Servers()
+		})
+		if server != nil || err != nil {
+			return server, err
+		}
+	}
 	log.Debugf("Brightbox API Call: Server Details for %s", d.MachineID)
 	return client.Server(d.MachineID)
 }
@@ -304,18 +704,27 @@ func (d *Driver) GetIP() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	mode, err := d.addressMode(server)
+	if err != nil {
+		return "", err
+	}
 	switch {
-	case d.IPv6:
-		tmp := ipv6Fqdn(server)
+	case mode == addressModePrivate:
+		tmp := d.privateAddress(server)
+		log.Debugf("Returning private address %s", tmp)
+		return tmp, nil
+	case mode == addressModeIPv6:
+		tmp := d.ipv6Address(server)
 		log.Debugf("Returning IPV6 address %s", tmp)
 		return tmp, nil
 	case len(server.CloudIPs) > 0:
-		tmp := publicFqdn(server)
+		tmp := d.publicAddress(server)
 		log.Debugf("Returning public address %s", tmp)
 		return tmp, nil
 	default:
-		log.Debugf("Returning private address %s", server.Fqdn)
-		return server.Fqdn, nil
+		tmp := d.privateAddress(server)
+		log.Debugf("Returning private address %s", tmp)
+		return tmp, nil
 	}
 }
 
@@ -331,16 +740,40 @@ func (d *Driver) publicSSHKeyPath() string {
 	return d.GetSSHKeyPath() + ".pub"
 }
 
+// With a bastion, docker-machine connects to the local end of the
+// machine's forwarder rather than to the server itself.
 func (d *Driver) GetSSHHostname() (string, error) {
-	return d.GetIP()
+	if !d.useTunnel() {
+		return d.GetIP()
+	}
+	if err := d.ensureTunnel(); err != nil {
+		return "", err
+	}
+	return "127.0.0.1", nil
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	if !d.useTunnel() {
+		return d.BaseDriver.GetSSHPort()
+	}
+	if err := d.ensureTunnel(); err != nil {
+		return 0, err
+	}
+	return d.TunnelSSHPort, nil
 }
 
 func (d *Driver) GetURL() (string, error) {
+	if d.Bastion != "" {
+		if err := d.ensureTunnel(); err != nil {
+			return "", err
+		}
+		return "tcp://" + net.JoinHostPort("localhost", strconv.Itoa(d.TunnelDockerPort)), nil
+	}
 	fqdn, err := d.GetIP()
 	if err != nil {
 		return "", err
 	}
-	return "tcp://" + fqdn + ":2376", nil
+	return "tcp://" + net.JoinHostPort(fqdn, strconv.Itoa(dockerPort)), nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
@@ -370,61 +803,138 @@ func (d *Driver) GetState() (state.State, error) {
 }
 
 func (d *Driver) Start() error {
+	d.liveDetails = true
+	defer d.invalidateServerCache()
+	if done, err := alreadyInState(d.MachineID, d.GetState, state.Running); done || err != nil {
+		return err
+	}
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 	log.Debugf("Brightbox API Call: Start Server %s", d.MachineID)
 	if err := client.StartServer(d.MachineID); err != nil {
-		return err
+		return fmt.Errorf("Start of server %s failed: %s", d.MachineID, err)
+	}
+	if err := d.waitForState(state.Running, startTimeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to start failed: %s", d.MachineID, err)
+	}
+	if err := d.waitForSSH("", startTimeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to start failed: %s", d.MachineID, err)
 	}
 	return nil
 }
 
 func (d *Driver) Stop() error {
+	d.liveDetails = true
+	defer d.invalidateServerCache()
+	if done, err := alreadyInState(d.MachineID, d.GetState, state.Stopped); done || err != nil {
+		return err
+	}
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 	log.Debugf("Brightbox API Call: Shutdown Server %s", d.MachineID)
 	if err := client.ShutdownServer(d.MachineID); err != nil {
-		return err
+		return fmt.Errorf("Shutdown of server %s failed: %s", d.MachineID, err)
+	}
+	timeout := d.stopTimeout()
+	err = d.waitForState(state.Stopped, timeout)
+	switch err.(type) {
+	case nil:
+		return nil
+	case *stateTimeoutError:
+		log.Warnf("%s. Stopping it instead", err)
+	default:
+		return fmt.Errorf("Waiting for server %s to shut down failed: %s", d.MachineID, err)
+	}
+	log.Debugf("Brightbox API Call: Stop Server %s", d.MachineID)
+	if err := client.StopServer(d.MachineID); err != nil {
+		return fmt.Errorf("Stop of server %s after shutdown timed out failed: %s", d.MachineID, err)
+	}
+	if err := d.waitForState(state.Stopped, timeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to stop failed: %s", d.MachineID, err)
 	}
 	return nil
 }
 
+// Machines created before the stop timeout option use the default
+func (d *Driver) stopTimeout() time.Duration {
+	if d.StopTimeout == 0 {
+		return defaultStopTimeout * time.Second
+	}
+	return time.Duration(d.StopTimeout) * time.Second
+}
+
 func (d *Driver) Restart() error {
+	d.liveDetails = true
+	defer d.invalidateServerCache()
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
+	previous, err := d.bootID()
+	if err != nil {
+		log.Debugf("Unable to read boot ID of server %s: %s", d.MachineID, err)
+	}
 	log.Debugf("Brightbox API Call: Reboot Server %s", d.MachineID)
 	if err := client.RebootServer(d.MachineID); err != nil {
-		return err
+		return fmt.Errorf("Reboot of server %s failed: %s", d.MachineID, err)
+	}
+	if err := d.waitForState(state.Running, restartTimeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to restart failed: %s", d.MachineID, err)
+	}
+	if err := d.waitForSSH(previous, restartTimeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to restart failed: %s", d.MachineID, err)
 	}
 	return nil
 }
 
 func (d *Driver) Kill() error {
+	d.liveDetails = true
+	defer d.invalidateServerCache()
+	if done, err := alreadyInState(d.MachineID, d.GetState, state.Stopped); done || err != nil {
+		return err
+	}
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 	log.Debugf("Brightbox API Call: Stop Server %s", d.MachineID)
 	if err := client.StopServer(d.MachineID); err != nil {
-		return err
+		return fmt.Errorf("Stop of server %s failed: %s", d.MachineID, err)
+	}
+	if err := d.waitForState(state.Stopped, killTimeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to stop failed: %s", d.MachineID, err)
 	}
 	return nil
 }
 
 func (d *Driver) Remove() error {
+	if d.MachineID == "" {
+		log.Debug("No server was created. Nothing to remove")
+		return nil
+	}
+	d.stopTunnel()
+	d.liveDetails = true
+	defer d.invalidateServerCache()
+	if done, err := alreadyInState(d.MachineID, d.deletionState, state.Stopped); done || err != nil {
+		return err
+	}
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 	log.Debugf("Brightbox API Call: Destroy Server %s", d.MachineID)
-	if err := client.DestroyServer(d.MachineID); err != nil {
-		return err
+	if err := client.DestroyServer(d.MachineID); isNotFound(err) {
+		log.Infof("Server %s has already been removed", d.MachineID)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Destroy of server %s failed: %s", d.MachineID, err)
+	}
+	if err := waitForState(d.MachineID, d.deletionState, state.Stopped, removeTimeout); err != nil {
+		return fmt.Errorf("Waiting for server %s to be deleted failed: %s", d.MachineID, err)
 	}
 	return nil
 }
@@ -1,8 +1,11 @@
 package brightbox
 
 import (
-	"github.com/brightbox/gobrightbox"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
 )
 
 type DriverOptionsMock struct {
@@ -94,6 +97,9 @@ func TestDefaultValues(t *testing.T) {
 	if driver.IPv6 != defaultIPV6 {
 		t.Errorf("Incorrect default IPV6: %s", driver.IPv6)
 	}
+	if driver.AddressMode != "" {
+		t.Errorf("Incorrect default AddressMode: %s", driver.AddressMode)
+	}
 	if driver.ServerGroups != nil {
 		t.Errorf("Incorrect default ServerGroups, %v", driver.ServerGroups)
 	}
@@ -101,9 +107,145 @@ func TestDefaultValues(t *testing.T) {
 		t.Errorf("Incorrect default Zone: %s", driver.Zone)
 	}
 	if driver.SSHPort != defaultSSHPort {
-		t.Errorf("Incorrect default SSHPort: %s", driver.SSHPort)
+		t.Errorf("Incorrect default SSHPort: %d", driver.SSHPort)
 	}
 	if *driver.Name != " (docker-machine)" {
 		t.Errorf("Incorrect default Name: %s", *driver.Name)
 	}
+	if driver.SSHKey != "" {
+		t.Errorf("Incorrect default SSHKey: %s", driver.SSHKey)
+	}
+	if driver.SSHUser != "" {
+		t.Errorf("Incorrect default SSHUser: %s", driver.SSHUser)
+	}
+}
+
+func TestSSHOverrides(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-ssh-key"] = "/home/test/.ssh/id_rsa"
+	flags.Data["brightbox-ssh-user"] = "ubuntu"
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Fatal("Unexpected set config failure with SSH overrides")
+	}
+	if driver.SSHKey != "/home/test/.ssh/id_rsa" {
+		t.Errorf("SSHKey not set: %s", driver.SSHKey)
+	}
+	if driver.GetSSHUsername() != "ubuntu" {
+		t.Errorf("SSHUser not set: %s", driver.GetSSHUsername())
+	}
+}
+
+func TestAddressModeValidation(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-address-mode"] = "ipv5"
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Invalid address mode not picked up")
+	}
+	flags.Data["brightbox-address-mode"] = "auto"
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Error("Auto address mode rejected")
+	}
+}
+
+func TestMaxHourlyCostValidation(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-max-hourly-cost"] = "cheap"
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Invalid maximum hourly cost not picked up")
+	}
+	flags.Data["brightbox-max-hourly-cost"] = "0.05"
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Missing price list not picked up")
+	}
+	flags.Data["brightbox-price-list"] = "prices.json"
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Error("Valid maximum hourly cost rejected")
+	}
+	if driver.MaxHourlyCost != 0.05 {
+		t.Errorf("MaxHourlyCost not set: %f", driver.MaxHourlyCost)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-label"] = []string{"team ops"}
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Invalid label not picked up")
+	}
+	flags.Data["brightbox-label"] = []string{"team=ops"}
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Error("Valid label rejected")
+	}
+	if *driver.Name != " (docker-machine) team=ops" {
+		t.Errorf("Label missing from Name: %s", *driver.Name)
+	}
+}
+
+func TestSSHPortValidation(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-ssh-port"] = 0
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Invalid SSH port not picked up")
+	}
+	flags.Data["brightbox-ssh-port"] = 2222
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Error("Valid SSH port rejected")
+	}
+	if driver.SSHPort != 2222 {
+		t.Errorf("SSHPort not set: %d", driver.SSHPort)
+	}
+}
+
+func getTestCloudInit(t *testing.T, driver *Driver) string {
+	userdata, err := driver.getCloudInit([]byte("ssh-rsa AAAAB3NzaC1yc2E test\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(userdata)
+}
+
+func TestCloudInitDefaultSSHPort(t *testing.T) {
+	driver := new(Driver)
+	driver.SSHPort = defaultSSHPort
+	userdata := getTestCloudInit(t, driver)
+	if !strings.HasPrefix(userdata, "#cloud-config\nssh_authorized_keys:\n  - 'ssh-rsa AAAAB3NzaC1yc2E test'\n") {
+		t.Errorf("Incorrect cloud-config: %s", userdata)
+	}
+	if strings.Contains(userdata, "sshd") {
+		t.Errorf("Unexpected sshd configuration: %s", userdata)
+	}
+}
+
+func TestCloudInitSSHPort(t *testing.T) {
+	driver := new(Driver)
+	driver.SSHPort = 2222
+	userdata := getTestCloudInit(t, driver)
+	if !strings.Contains(userdata, "$aPort 2222' /etc/ssh/sshd_config") {
+		t.Errorf("sshd_config not updated: %s", userdata)
+	}
+	if !strings.Contains(userdata, "ListenStream=2222") {
+		t.Errorf("sshd.socket not updated: %s", userdata)
+	}
+}
+
+func TestCreatedServers(t *testing.T) {
+	requested := time.Now()
+	before := requested.Add(-time.Hour)
+	after := requested.Add(time.Second)
+	name := "web1 (docker-machine)"
+	servers := []brightbox.Server{
+		{Resource: brightbox.Resource{Id: "srv-new"}, Name: name, Status: "creating", CreatedAt: &after},
+		{Resource: brightbox.Resource{Id: "srv-old"}, Name: name, Status: "active", CreatedAt: &before},
+		{Resource: brightbox.Resource{Id: "srv-gone"}, Name: name, Status: "deleted", CreatedAt: &after},
+		{Resource: brightbox.Resource{Id: "srv-other"}, Name: "web2 (docker-machine)", Status: "creating", CreatedAt: &after},
+	}
+	ids := createdServers(servers, name, requested)
+	if len(ids) != 1 || ids[0] != "srv-new" {
+		t.Errorf("Incorrect servers selected: %v", ids)
+	}
 }
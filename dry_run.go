@@ -0,0 +1,66 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/log"
+)
+
+var errDryRun = errors.New("Dry run: server not created")
+
+// Stands in for the SSH key the driver would generate, which is only
+// created along with the server.
+const dryRunSSHKey = "ssh-rsa (generated when the server is created)"
+
+// dryRunRequest shows the user data decoded rather than base64 encoded
+type dryRunRequest struct {
+	brightbox.ServerOptions
+	UserData string `json:"user_data"`
+}
+
+func (d *Driver) dryRunJSON(userdata []byte) ([]byte, error) {
+	request := dryRunRequest{
+		ServerOptions: d.ServerOptions,
+		UserData:      redactText(string(userdata)),
+	}
+	return json.MarshalIndent(request, "", "  ")
+}
+
+// dryRun builds the server creation request in memory and prints it.
+// Nothing is written to the machine store: no keys, secrets or cached
+// tokens.
+func (d *Driver) dryRun() error {
+	userdata, err := d.dryRunUserData()
+	if err != nil {
+		return err
+	}
+	return d.printDryRun(userdata)
+}
+
+func (d *Driver) dryRunUserData() ([]byte, error) {
+	publickey := []byte(dryRunSSHKey)
+	if d.SSHKey != "" {
+		var err error
+		if publickey, err = readPublicKey(d.SSHKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.createHostKey(); err != nil {
+		return nil, err
+	}
+	defer func() { d.hostKey = nil }()
+	return d.getCloudInit(publickey)
+}
+
+// printDryRun shows the server creation request that would have been
+// sent, and stops the creation.
+func (d *Driver) printDryRun(userdata []byte) error {
+	output, err := d.dryRunJSON(userdata)
+	if err != nil {
+		return err
+	}
+	log.Infof("Dry run. The server would be created with:\n%s", output)
+	return errDryRun
+}
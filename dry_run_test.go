@@ -0,0 +1,69 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDryRunJSON(t *testing.T) {
+	driver := new(Driver)
+	driver.Image = "img-12345"
+	driver.ServerType = "1gb.ssd"
+	encoded := "I2Nsb3VkLWNvbmZpZw=="
+	driver.UserData = &encoded
+	output, err := driver.dryRunJSON([]byte("#cloud-config\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request map[string]interface{}
+	if err := json.Unmarshal(output, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request["user_data"] != "#cloud-config\n" {
+		t.Errorf("User data not decoded: %v", request["user_data"])
+	}
+	if request["image"] != "img-12345" || request["server_type"] != "1gb.ssd" {
+		t.Errorf("Server options missing: %s", output)
+	}
+}
+
+func TestRemoveWithoutServer(t *testing.T) {
+	driver := new(Driver)
+	if err := driver.Remove(); err != nil {
+		t.Errorf("Removing a machine with no server failed: %s", err)
+	}
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver := NewDriver("test", dir)
+	driver.DryRun = true
+	driver.SSHPort = defaultSSHPort
+	if err := driver.dryRun(); err != errDryRun {
+		t.Fatalf("Unexpected dry run result: %v", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Dry run wrote %d files to the store", len(files))
+	}
+	if driver.UserData != nil {
+		t.Error("User data kept by the driver")
+	}
+	userdata, err := driver.dryRunUserData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(userdata), dryRunSSHKey) || !strings.Contains(string(userdata), "ecdsa_public:") {
+		t.Errorf("Incomplete dry run user data: %s", userdata)
+	}
+}
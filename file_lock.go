@@ -0,0 +1,52 @@
+package brightbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Locks are held while the API is called, so how long they are kept
+// for depends on the API timeout. On top of that, a lock is assumed to
+// have been abandoned by a process that died once it is fileLockStale
+// older than it should be held for, and a waiting plugin gives up
+// fileLockWait after that, so it always gets the chance to remove an
+// abandoned lock first.
+var (
+	fileLockWait  = 40 * time.Second
+	fileLockStale = 30 * time.Second
+	fileLockPoll  = 50 * time.Millisecond
+)
+
+// lockFile creates the lock file at path, waiting while another process
+// holds it, and returns a function that releases it. hold is the
+// longest a process may keep the lock for.
+func lockFile(path string, hold time.Duration) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	stale := hold + fileLockStale
+	deadline := time.Now().Add(hold + fileLockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > stale {
+			log.Debugf("Removing stale lock %s", path)
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for lock %s", path)
+		}
+		time.Sleep(fileLockPoll)
+	}
+}
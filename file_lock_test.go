@@ -0,0 +1,29 @@
+package brightbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFileHeld(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock := filepath.Join(dir, "test.lock")
+	unlock, err := lockFile(lock, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	saved := fileLockWait
+	fileLockWait = 20 * time.Millisecond
+	defer func() { fileLockWait = saved }()
+	if _, err := lockFile(lock, 0); err == nil {
+		t.Error("Held lock taken twice")
+	}
+}
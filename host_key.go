@@ -0,0 +1,156 @@
+package brightbox
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const hostKeyFileName = "ssh_host_key.pub"
+
+// Installs the host key the driver generated, so it is known before
+// the first connection. ssh_keys handles cloud-init based images,
+// write_files handles CoreOS, which generates its remaining keys
+// around it.
+const hostKeyCloudInit = `ssh_keys:
+  ecdsa_private: |
+%[1]s
+  ecdsa_public: %[2]s
+write_files:
+  - path: /etc/ssh/ssh_host_ecdsa_key
+    permissions: "0600"
+    content: |
+%[3]s
+  - path: /etc/ssh/ssh_host_ecdsa_key.pub
+    permissions: "0644"
+    content: %[2]s
+`
+
+func (d *Driver) hostKeyPath() string {
+	return d.ResolveStorePath(hostKeyFileName)
+}
+
+// generateHostKey returns a new ECDSA host key as a PEM private key and
+// an authorized_keys format public key.
+func generateHostKey() (private, public []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sshKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	private = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return private, ssh.MarshalAuthorizedKey(sshKey), nil
+}
+
+// createHostKey generates the server's SSH host key. It is only held
+// in memory: Create drops the private half once the server has been
+// requested, and the user data carrying it is never saved.
+func (d *Driver) createHostKey() error {
+	private, public, err := generateHostKey()
+	if err != nil {
+		return err
+	}
+	d.hostKey = private
+	d.hostKeyPublic = public
+	return nil
+}
+
+// pinHostKey saves the public half of the host key next to the
+// machine's SSH key.
+func (d *Driver) pinHostKey() error {
+	log.Debugf("Pinning SSH host key in %s", d.hostKeyPath())
+	return ioutil.WriteFile(d.hostKeyPath(), d.hostKeyPublic, 0644)
+}
+
+// writeHostKeyCloudInit adds the generated host key to the cloud-config
+func (d *Driver) writeHostKeyCloudInit(data *bytes.Buffer) error {
+	if d.hostKey == nil {
+		return nil
+	}
+	fmt.Fprintf(data, hostKeyCloudInit,
+		indent(d.hostKey, "    "),
+		bytes.TrimSpace(d.hostKeyPublic),
+		indent(d.hostKey, "      "))
+	return nil
+}
+
+func indent(text []byte, prefix string) string {
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix)
+}
+
+// readPinnedHostKey reads the host key pinned when the server was
+// created. Without it the server can't be checked, so it is an error
+// for the key to be missing.
+func readPinnedHostKey(path string) (ssh.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Refusing to connect without the pinned SSH host key: %s", err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read pinned SSH host key %s: %s", path, err)
+	}
+	return key, nil
+}
+
+// dialServer logs in to the server at address with the machine's SSH
+// key, directly or through via, and refuses the connection unless the
+// server presents the host key pinned in hostKeyPath.
+func dialServer(via *ssh.Client, address, user, keyPath, hostKeyPath string) (*ssh.Client, error) {
+	pinned, err := readPinnedHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read machine SSH key: %s", err)
+	}
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !bytes.Equal(key.Marshal(), pinned.Marshal()) {
+				return fmt.Errorf("SSH host key for %s does not match the key pinned in %s", hostname, hostKeyPath)
+			}
+			return nil
+		},
+		// Ask for the pinned key rather than any other the server has
+		HostKeyAlgorithms: []string{pinned.Type()},
+	}
+	log.Debugf("Connecting to server %s", address)
+	if via == nil {
+		return ssh.Dial("tcp", address, config)
+	}
+	conn, err := via.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	client, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(client, channels, requests), nil
+}
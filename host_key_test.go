@@ -0,0 +1,105 @@
+package brightbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKeyDriver(t *testing.T) (*Driver, func()) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := NewDriver("test", dir)
+	if err := os.MkdirAll(filepath.Dir(driver.hostKeyPath()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &driver, func() { os.RemoveAll(dir) }
+}
+
+func TestCreateHostKey(t *testing.T) {
+	driver, cleanup := newHostKeyDriver(t)
+	defer cleanup()
+	if err := driver.createHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.pinHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(driver.hostKey)
+	if err != nil {
+		t.Fatalf("Generated host key unreadable: %s", err)
+	}
+	pinned, err := ioutil.ReadFile(driver.hostKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pinned, ssh.MarshalAuthorizedKey(signer.PublicKey())) {
+		t.Errorf("Pinned key does not match generated key: %s", pinned)
+	}
+	var data bytes.Buffer
+	if err := driver.writeHostKeyCloudInit(&data); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"ssh_keys:", "ecdsa_private:", "/etc/ssh/ssh_host_ecdsa_key", string(bytes.TrimSpace(pinned))} {
+		if !strings.Contains(data.String(), expected) {
+			t.Errorf("Cloud-config missing %q:\n%s", expected, data.String())
+		}
+	}
+}
+
+func TestDialServerPinnedHostKey(t *testing.T) {
+	driver, cleanup := newHostKeyDriver(t)
+	defer cleanup()
+	keyPath, _ := writeTestPrivateKey(t, filepath.Dir(driver.hostKeyPath()))
+	server := newTestSSHServer(t)
+	defer server.listener.Close()
+	address := server.listener.Addr().String()
+	if _, err := dialServer(nil, address, "docker", keyPath, driver.hostKeyPath()); err == nil {
+		t.Error("Connected without a pinned host key")
+	}
+	if err := ioutil.WriteFile(driver.hostKeyPath(), ssh.MarshalAuthorizedKey(server.hostKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err := dialServer(nil, address, "docker", keyPath, driver.hostKeyPath())
+	if err != nil {
+		t.Fatalf("Pinned host key rejected: %s", err)
+	}
+	client.Close()
+	if err := driver.createHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.pinHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dialServer(nil, address, "docker", keyPath, driver.hostKeyPath()); err == nil {
+		t.Error("Different host key accepted")
+	}
+}
+
+func TestTunnelSpecNeedsPinnedHostKey(t *testing.T) {
+	driver, cleanup := newHostKeyDriver(t)
+	defer cleanup()
+	driver.HostKeyPinned = true
+	if _, err := driver.tunnelSpec(); err == nil {
+		t.Error("Missing pinned host key not picked up")
+	}
+}
+
+func TestUseTunnelForStrictHostKey(t *testing.T) {
+	driver := new(Driver)
+	driver.HostKeyPinned = true
+	if driver.useTunnel() {
+		t.Error("Forwarder used without --brightbox-strict-host-key")
+	}
+	driver.StrictHostKey = true
+	if !driver.useTunnel() {
+		t.Error("Forwarder not used with --brightbox-strict-host-key")
+	}
+}
@@ -0,0 +1,53 @@
+package brightbox
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxLabelLength = 63
+	maxFQDNLength  = 253
+)
+
+var (
+	invalidHostnameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+	repeatedHyphens      = regexp.MustCompile(`-{2,}`)
+	domainLabelPattern   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+)
+
+// hostnameLabel turns a machine name into a valid DNS label, e.g.
+// "Web_Server 1" becomes "web-server-1". It returns an empty string if
+// nothing usable is left.
+func hostnameLabel(machineName string) string {
+	label := invalidHostnameChars.ReplaceAllString(strings.ToLower(machineName), "-")
+	label = repeatedHyphens.ReplaceAllString(label, "-")
+	label = strings.Trim(label, "-")
+	if len(label) > maxLabelLength {
+		label = strings.TrimRight(label[:maxLabelLength], "-")
+	}
+	return label
+}
+
+// normaliseDomain lower cases the domain and drops any trailing dot
+func normaliseDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// checkDomain makes sure the domain, with a hostname in front of it,
+// makes a valid fully qualified domain name.
+func checkDomain(domain string) error {
+	if domain == "" {
+		return nil
+	}
+	if len(domain) > maxFQDNLength-maxLabelLength-1 {
+		return fmt.Errorf("Domain %s is too long", domain)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) > maxLabelLength || !domainLabelPattern.MatchString(label) {
+			return fmt.Errorf("Domain %s is not a valid DNS name", domain)
+		}
+	}
+	return nil
+}
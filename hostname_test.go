@@ -0,0 +1,56 @@
+package brightbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostnameLabel(t *testing.T) {
+	cases := map[string]string{
+		"web1":                         "web1",
+		"Web_Server 1":                 "web-server-1",
+		"--docker..host--":             "docker-host",
+		"___":                          "",
+		strings.Repeat("a", 70):        strings.Repeat("a", 63),
+		strings.Repeat("a", 62) + "-b": strings.Repeat("a", 62),
+	}
+	for name, expected := range cases {
+		if label := hostnameLabel(name); label != expected {
+			t.Errorf("Hostname for %q is %q, expected %q", name, label, expected)
+		}
+	}
+}
+
+func TestDomainValidation(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	for _, domain := range []string{"-bad.example.com", "bad..example.com", "bad_domain.com", strings.Repeat("a.", 100) + "com"} {
+		flags.Data["brightbox-domain"] = domain
+		if err := driver.SetConfigFromFlags(flags); err == nil {
+			t.Errorf("Invalid domain %s not picked up", domain)
+		}
+	}
+	flags.Data["brightbox-domain"] = "Docker.Example.com."
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Errorf("Valid domain rejected: %s", err)
+	}
+	if driver.Domain != "docker.example.com" {
+		t.Errorf("Domain not normalised: %s", driver.Domain)
+	}
+}
+
+func TestCloudInitHostname(t *testing.T) {
+	driver := NewDriver("Web_Server 1", "")
+	userdata := getTestCloudInit(t, &driver)
+	if !strings.Contains(userdata, "\nhostname: web-server-1\n") {
+		t.Errorf("Hostname not set: %s", userdata)
+	}
+	if strings.Contains(userdata, "fqdn:") {
+		t.Errorf("FQDN set without a domain: %s", userdata)
+	}
+	driver.Domain = "docker.example.com"
+	userdata = getTestCloudInit(t, &driver)
+	if !strings.Contains(userdata, "\nfqdn: web-server-1.docker.example.com\n") {
+		t.Errorf("FQDN not set: %s", userdata)
+	}
+}
@@ -0,0 +1,74 @@
+package brightbox
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var labelPattern = regexp.MustCompile(`^([A-Za-z0-9_.-]+)=([A-Za-z0-9_.:/@+-]*)$`)
+
+// checkLabels makes sure each label is a key=value pair that can be
+// written into the server name and read back unambiguously.
+func checkLabels(labels []string) error {
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		match := labelPattern.FindStringSubmatch(label)
+		if match == nil {
+			return fmt.Errorf("Label %q must be key=value, using letters, digits and _.- in the key and no spaces", label)
+		}
+		if seen[match[1]] {
+			return fmt.Errorf("Label %s given more than once", match[1])
+		}
+		seen[match[1]] = true
+	}
+	return nil
+}
+
+const serverNameMarker = " (docker-machine)"
+
+// serverName marks the server as created by docker-machine, followed
+// by any labels, e.g. "web1 (docker-machine) team=ops env=prod"
+func serverName(machineName string, labels []string) string {
+	name := machineName + serverNameMarker
+	if len(labels) > 0 {
+		name += " " + strings.Join(labels, " ")
+	}
+	return name
+}
+
+// parseServerName reads the machine name and labels back out of a
+// server name made by serverName. Labels can't contain the marker, so
+// the last one found ends the machine name, even if the machine name
+// contains the marker itself.
+func parseServerName(name string) (machineName string, labels []string, ok bool) {
+	i := strings.LastIndex(name, serverNameMarker)
+	if i < 0 {
+		return "", nil, false
+	}
+	rest := name[i+len(serverNameMarker):]
+	if rest != "" && !strings.HasPrefix(rest, " ") {
+		return "", nil, false
+	}
+	if labels = strings.Fields(rest); len(labels) == 0 {
+		labels = nil
+	}
+	if checkLabels(labels) != nil {
+		return "", nil, false
+	}
+	return name[:i], labels, true
+}
+
+// ServerLabels reads the labels back from the server's current name, so
+// scripts see any change made to it since the machine was created.
+func (d *Driver) ServerLabels() ([]string, error) {
+	server, err := d.getServerDetails()
+	if err != nil {
+		return nil, err
+	}
+	_, labels, ok := parseServerName(server.Name)
+	if !ok {
+		return nil, fmt.Errorf("Server %s name %q has no docker-machine labels", d.MachineID, server.Name)
+	}
+	return labels, nil
+}
@@ -0,0 +1,62 @@
+package brightbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckLabels(t *testing.T) {
+	if err := checkLabels([]string{"team=ops", "env=prod", "owner=fred@example.com", "empty="}); err != nil {
+		t.Errorf("Valid labels rejected: %s", err)
+	}
+	invalid := [][]string{
+		{"team"},
+		{"=ops"},
+		{"team=two words"},
+		{"team=(ops)"},
+		{"team=ops", "team=dev"},
+	}
+	for _, labels := range invalid {
+		if err := checkLabels(labels); err == nil {
+			t.Errorf("Invalid labels %v accepted", labels)
+		}
+	}
+}
+
+func TestServerName(t *testing.T) {
+	if name := serverName("web1", nil); name != "web1 (docker-machine)" {
+		t.Errorf("Incorrect unlabelled name: %s", name)
+	}
+	if name := serverName("web1", []string{"team=ops", "env=prod"}); name != "web1 (docker-machine) team=ops env=prod" {
+		t.Errorf("Incorrect labelled name: %s", name)
+	}
+}
+
+func TestParseServerName(t *testing.T) {
+	cases := []struct {
+		machineName string
+		labels      []string
+	}{
+		{"web1", nil},
+		{"web1", []string{"team=ops", "env=prod"}},
+		{"web1 (docker-machine)", []string{"team=ops"}},
+		{"web1 (docker-machine) team=ops", nil},
+		{"", []string{"owner=fred@example.com", "empty="}},
+	}
+	for _, c := range cases {
+		name := serverName(c.machineName, c.labels)
+		machineName, labels, ok := parseServerName(name)
+		if !ok {
+			t.Errorf("Server name %q not parsed", name)
+			continue
+		}
+		if machineName != c.machineName || !reflect.DeepEqual(labels, c.labels) {
+			t.Errorf("Server name %q parsed as %q %v", name, machineName, labels)
+		}
+	}
+	for _, name := range []string{"web1", "web1 (docker-machine)team=ops", "web1 (docker-machine) team"} {
+		if _, _, ok := parseServerName(name); ok {
+			t.Errorf("Server name %q parsed", name)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package brightbox
+
+import (
+	"fmt"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// checkPlacement makes sure the requested zone and server groups exist
+// before the server is created.
+func (d *Driver) checkPlacement() error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+	if d.Zone != "" {
+		log.Debug("Brightbox API Call: List of Zones")
+		zones, err := client.Zones()
+		if err != nil {
+			return err
+		}
+		if err := checkZone(zones, d.Zone); err != nil {
+			return err
+		}
+	}
+	if d.ServerGroups != nil {
+		log.Debug("Brightbox API Call: List of Server Groups")
+		groups, err := client.ServerGroups()
+		if err != nil {
+			return err
+		}
+		if err := checkServerGroups(groups, *d.ServerGroups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkZone(zones []brightbox.Zone, name string) error {
+	for _, zone := range zones {
+		if zone.Id == name || zone.Handle == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown zone %s", name)
+}
+
+func checkServerGroups(groups []brightbox.ServerGroup, names []string) error {
+	known := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		known[group.Id] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("Unknown server group %s", name)
+		}
+	}
+	return nil
+}
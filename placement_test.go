@@ -0,0 +1,34 @@
+package brightbox
+
+import (
+	"testing"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+func TestCheckZone(t *testing.T) {
+	zones := []brightbox.Zone{
+		{Resource: brightbox.Resource{Id: "zon-remk1"}, Handle: "gb1-a"},
+	}
+	for _, name := range []string{"zon-remk1", "gb1-a"} {
+		if err := checkZone(zones, name); err != nil {
+			t.Errorf("Zone %s rejected", name)
+		}
+	}
+	if err := checkZone(zones, "gb1-c"); err == nil {
+		t.Error("Unknown zone accepted")
+	}
+}
+
+func TestCheckServerGroups(t *testing.T) {
+	groups := []brightbox.ServerGroup{
+		{Resource: brightbox.Resource{Id: "grp-12345"}},
+		{Resource: brightbox.Resource{Id: "grp-abcde"}},
+	}
+	if err := checkServerGroups(groups, []string{"grp-abcde", "grp-12345"}); err != nil {
+		t.Errorf("Server groups rejected: %s", err)
+	}
+	if err := checkServerGroups(groups, []string{"grp-12345", "grp-xxxxx"}); err == nil {
+		t.Error("Unknown server group accepted")
+	}
+}
@@ -0,0 +1,124 @@
+package brightbox
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// All driver output that might contain secrets passes through the
+// functions in this file before it is logged, dumped or printed.
+
+const (
+	redacted = "[REDACTED]"
+
+	// Shorter values are too likely to match innocent text
+	minSecretLength = 4
+)
+
+// Field names whose values are never written out
+var secretFields = map[string]bool{
+	"client_secret": true,
+	"password":      true,
+	"access_token":  true,
+	"refresh_token": true,
+	"console_token": true,
+	"APISecret":     true,
+}
+
+// Base64 encoded fields, such as the user data holding the server's
+// host key, which are decoded so what they hold can be masked
+var encodedFields = map[string]bool{
+	"user_data": true,
+}
+
+var (
+	privateKeyPattern = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+	assignmentPattern = regexp.MustCompile(`(?i)((?:client_secret|password|access_token|refresh_token|console_token)["']?\s*[:=]\s*["']?)[^\s"'&,}]+`)
+
+	secretsMu    sync.Mutex //guards secretValues
+	secretValues []string
+)
+
+// addSecret records a value, such as a client secret or token, that is
+// masked wherever it turns up in text passed to redactText.
+func addSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, existing := range secretValues {
+		if existing == value {
+			return
+		}
+	}
+	secretValues = append(secretValues, value)
+}
+
+// redactText masks private key material, secret assignments and any
+// recorded secret values in free text.
+func redactText(text string) string {
+	text = privateKeyPattern.ReplaceAllString(text, redacted)
+	text = assignmentPattern.ReplaceAllString(text, "${1}"+redacted)
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, value := range secretValues {
+		text = strings.Replace(text, value, redacted, -1)
+	}
+	return text
+}
+
+// redactBody masks secret fields in a JSON or form encoded body. Other
+// bodies are treated as free text.
+func redactBody(body []byte) []byte {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err == nil {
+		result, err := json.Marshal(redactJSON(data))
+		if err == nil {
+			return []byte(redactText(string(result)))
+		}
+	}
+	if form, err := url.ParseQuery(string(body)); err == nil && strings.Contains(string(body), "=") {
+		for key := range form {
+			if secretFields[key] {
+				form.Set(key, redacted)
+			}
+		}
+		return []byte(redactText(form.Encode()))
+	}
+	return []byte(redactText(string(body)))
+}
+
+func redactJSON(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if secretFields[key] {
+				value[key] = redacted
+			} else if text, ok := item.(string); ok && encodedFields[key] {
+				value[key] = redactEncoded(text)
+			} else {
+				value[key] = redactJSON(item)
+			}
+		}
+	case []interface{}:
+		for index, item := range value {
+			value[index] = redactJSON(item)
+		}
+	}
+	return data
+}
+
+// redactEncoded decodes a base64 value and masks its contents. Values
+// that aren't base64 are masked as they are.
+func redactEncoded(text string) string {
+	decoded, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return redactText(text)
+	}
+	return redactText(string(decoded))
+}
@@ -0,0 +1,60 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const secretsFileName = "brightbox_secrets.json"
+
+// storedSecrets are the credentials kept in the secrets file rather
+// than the machine's config.json
+type storedSecrets struct {
+	ClientSecret string `json:"client_secret"`
+}
+
+func (d *Driver) secretsPath() string {
+	return d.ResolveStorePath(secretsFileName)
+}
+
+// saveSecrets writes the client secret to a file only the owner can
+// read, if the secrets file option is on.
+func (d *Driver) saveSecrets() error {
+	if !d.SecretsFile {
+		return nil
+	}
+	path := d.secretsPath()
+	log.Debugf("Saving client secret to %s", path)
+	data, err := json.Marshal(storedSecrets{ClientSecret: d.apiSecret})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// loadSecrets reads the client secret back from the secrets file when
+// it isn't already held in memory.
+func (d *Driver) loadSecrets() error {
+	if !d.SecretsFile || d.apiSecret != "" {
+		return nil
+	}
+	path := d.secretsPath()
+	log.Debugf("Reading client secret from %s", path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var secrets storedSecrets
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return err
+	}
+	d.apiSecret = secrets.ClientSecret
+	return nil
+}
@@ -0,0 +1,95 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretsFileKeepsSecretOutOfConfig(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-secrets-file"] = true
+	if err := driver.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "abcdefg") {
+		t.Errorf("Client secret in config: %s", config)
+	}
+	if driver.clientSecret() != "abcdefg" {
+		t.Errorf("Client secret lost: %s", driver.clientSecret())
+	}
+}
+
+func TestSecretsFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver := NewDriver("test", dir)
+	driver.SecretsFile = true
+	driver.apiSecret = "abcdefg"
+	if err := driver.saveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(driver.secretsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Incorrect secrets file permissions: %v", info.Mode())
+	}
+	restored := NewDriver("test", dir)
+	restored.SecretsFile = true
+	if err := restored.loadSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.clientSecret() != "abcdefg" {
+		t.Errorf("Client secret not restored: %s", restored.clientSecret())
+	}
+}
+
+func TestProcessCredentialsNotSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver := NewDriver("test", dir)
+	driver.SecretsFile = true
+	output := filepath.Join(dir, "credentials.json")
+	creds := `{"Version": 1, "ClientId": "cli-process", "ClientSecret": "processsecret", "UserName": "fred@example.com", "Password": "hunter2"}`
+	if err := ioutil.WriteFile(output, []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+	driver.CredentialProcess = "cat " + output
+	if err := driver.runCredentialProcess(); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.saveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := ioutil.ReadFile(driver.secretsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(&driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, saved := range []string{string(secrets), string(config)} {
+		for _, value := range []string{"cli-process", "processsecret", "fred@example.com", "hunter2"} {
+			if strings.Contains(saved, value) {
+				t.Errorf("Process credential %s saved: %s", value, saved)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const serverCacheFileName = "brightbox_servers.json"
+
+// How long a server listing is used for before it is fetched again
+var serverCacheTTL = 10 * time.Second
+
+// serverListing is one account's servers as at Fetched
+type serverListing struct {
+	Fetched time.Time
+	Servers []brightbox.Server
+}
+
+// serverCache is a file in the docker-machine store holding recent
+// server listings, so that commands such as docker-machine ls look up
+// every machine in an account with a single API call.
+type serverCache struct {
+	path string
+	key  string
+	hold time.Duration
+}
+
+// sharedServerCache returns the cache for the driver's account, or nil
+// if there is no store to keep it in. The cache is locked for as long
+// as listing the servers may take.
+func (d *Driver) sharedServerCache() *serverCache {
+	if d.StorePath == "" {
+		return nil
+	}
+	return &serverCache{
+		path: filepath.Join(d.StorePath, serverCacheFileName),
+		key:  strings.Join([]string{d.APIURL, d.Account}, " "),
+		hold: d.apiTimeout(),
+	}
+}
+
+// server returns the server with the given ID from a fresh listing,
+// calling fetch to list the servers again if the cached listing is too
+// old. It returns nil if the server isn't in the listing. The cache
+// stays locked while fetch runs, so plugins looking up servers at the
+// same time wait for the one listing them and then use its listing.
+func (c *serverCache) server(id string, fetch func() ([]brightbox.Server, error)) (*brightbox.Server, error) {
+	unlock, err := lockFile(c.path+".lock", c.hold)
+	if err != nil {
+		log.Debugf("Server cache unavailable: %s", err)
+		return nil, nil
+	}
+	defer unlock()
+	listings := c.read()
+	listing := listings[c.key]
+	if listing == nil || time.Since(listing.Fetched) > serverCacheTTL {
+		servers, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		listing = &serverListing{Fetched: time.Now(), Servers: servers}
+		listings[c.key] = listing
+		if err := c.write(listings); err != nil {
+			log.Debugf("Unable to update server cache: %s", err)
+		}
+	} else {
+		log.Debug("Using server details from the server cache")
+	}
+	for i := range listing.Servers {
+		if listing.Servers[i].Id == id {
+			return &listing.Servers[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// invalidate drops the account's listing, so the next lookup sees any
+// change just made.
+func (c *serverCache) invalidate() {
+	unlock, err := lockFile(c.path+".lock", c.hold)
+	if err != nil {
+		log.Debugf("Server cache unavailable: %s", err)
+		return
+	}
+	defer unlock()
+	listings := c.read()
+	if _, ok := listings[c.key]; !ok {
+		return
+	}
+	delete(listings, c.key)
+	if err := c.write(listings); err != nil {
+		log.Debugf("Unable to update server cache: %s", err)
+	}
+}
+
+// read returns the listings in the cache. A missing or corrupt cache is
+// treated as empty.
+func (c *serverCache) read() map[string]*serverListing {
+	listings := make(map[string]*serverListing)
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return listings
+	}
+	if err := json.Unmarshal(data, &listings); err != nil {
+		log.Debugf("Ignoring unreadable server cache: %s", err)
+		return make(map[string]*serverListing)
+	}
+	return listings
+}
+
+// write replaces the cache file, readable only by its owner
+func (c *serverCache) write(listings map[string]*serverListing) error {
+	data, err := json.Marshal(listings)
+	if err != nil {
+		return err
+	}
+	temp := c.path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, c.path)
+}
+
+// invalidateServerCache is deferred by each change to the server's
+// state, so the shared listing is dropped once the change and the wait
+// for it are complete. Until then other processes may still cache a
+// listing from part way through. The changing process sets liveDetails
+// instead, to read the server's details directly and see each change
+// as it happens.
+func (d *Driver) invalidateServerCache() {
+	if cache := d.sharedServerCache(); cache != nil {
+		cache.invalidate()
+	}
+}
@@ -0,0 +1,118 @@
+package brightbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+func testServerCache(t *testing.T) (*serverCache, func()) {
+	dir, err := ioutil.TempDir("", "brightbox-server-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &serverCache{
+		path: filepath.Join(dir, serverCacheFileName),
+		key:  "https://api.gb1.brightbox.com acc-12345",
+	}
+	return cache, func() { os.RemoveAll(dir) }
+}
+
+func countingList(count *int) func() ([]brightbox.Server, error) {
+	return func() ([]brightbox.Server, error) {
+		*count++
+		servers := make([]brightbox.Server, 2)
+		servers[0].Id = "srv-aaaaa"
+		servers[0].Status = "active"
+		servers[1].Id = "srv-bbbbb"
+		servers[1].Status = "inactive"
+		return servers, nil
+	}
+}
+
+func TestServerCacheShared(t *testing.T) {
+	cache, cleanup := testServerCache(t)
+	defer cleanup()
+	var fetches int
+	first, err := cache.server("srv-aaaaa", countingList(&fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cache.server("srv-bbbbb", countingList(&fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 {
+		t.Errorf("Listing fetched %d times", fetches)
+	}
+	if first == nil || first.Status != "active" || second == nil || second.Status != "inactive" {
+		t.Errorf("Incorrect servers returned: %v, %v", first, second)
+	}
+	missing, err := cache.server("srv-ccccc", countingList(&fetches))
+	if missing != nil || err != nil {
+		t.Errorf("Unknown server returned: %v, %v", missing, err)
+	}
+}
+
+func TestServerCacheExpiry(t *testing.T) {
+	cache, cleanup := testServerCache(t)
+	defer cleanup()
+	saved := serverCacheTTL
+	serverCacheTTL = 0
+	defer func() { serverCacheTTL = saved }()
+	var fetches int
+	cache.server("srv-aaaaa", countingList(&fetches))
+	time.Sleep(time.Millisecond)
+	cache.server("srv-aaaaa", countingList(&fetches))
+	if fetches != 2 {
+		t.Errorf("Expired listing used: %d fetches", fetches)
+	}
+}
+
+func TestServerCacheInvalidate(t *testing.T) {
+	cache, cleanup := testServerCache(t)
+	defer cleanup()
+	var fetches int
+	cache.server("srv-aaaaa", countingList(&fetches))
+	cache.invalidate()
+	cache.server("srv-aaaaa", countingList(&fetches))
+	if fetches != 2 {
+		t.Errorf("Invalidated listing used: %d fetches", fetches)
+	}
+}
+
+func TestServerCacheConcurrentMisses(t *testing.T) {
+	cache, cleanup := testServerCache(t)
+	defer cleanup()
+	var mu sync.Mutex
+	var fetches int
+	list := countingList(new(int))
+	fetch := func() ([]brightbox.Server, error) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		return list()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each lookup stands for a separate plugin process
+			other := &serverCache{path: cache.path, key: cache.key}
+			if server, err := other.server("srv-aaaaa", fetch); err != nil || server == nil {
+				t.Errorf("Server not found: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("Concurrent lookups listed servers %d times", fetches)
+	}
+}
@@ -0,0 +1,92 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// The Brightbox API doesn't publish prices, so they come from a price
+// list file mapping server type handles to hourly prices.
+type priceList map[string]float64
+
+func readPriceList(path string) (priceList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prices priceList
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("Unable to read price list %s: %s", path, err)
+	}
+	return prices, nil
+}
+
+// findServerType looks up the server type by ID or handle
+func findServerType(serverTypes []brightbox.ServerType, name string) (*brightbox.ServerType, error) {
+	for index := range serverTypes {
+		if serverTypes[index].Id == name || serverTypes[index].Handle == name {
+			return &serverTypes[index], nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown server type %s", name)
+}
+
+// checkServerType makes sure the server will fit within the account's
+// limits and, if there is a price list, within the cost limit.
+func (d *Driver) checkServerType() error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+	log.Debug("Brightbox API Call: List of Server Types")
+	serverTypes, err := client.ServerTypes()
+	if err != nil {
+		return err
+	}
+	serverType, err := findServerType(serverTypes, d.ServerType)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Brightbox API Call: Account Details for %s", d.Account)
+	account, err := client.Account(d.Account)
+	if err != nil {
+		return err
+	}
+	if err := checkRAMLimit(account, serverType); err != nil {
+		return err
+	}
+	return d.checkHourlyCost(serverType)
+}
+
+func checkRAMLimit(account *brightbox.Account, serverType *brightbox.ServerType) error {
+	available := account.RamLimit - account.RamUsed
+	log.Debugf("Account %s has %dMB of its %dMB RAM limit available", account.Id, available, account.RamLimit)
+	if serverType.Ram > available {
+		return fmt.Errorf("Server type %s needs %dMB RAM but account %s has only %dMB of its %dMB limit available",
+			serverType.Handle, serverType.Ram, account.Id, available, account.RamLimit)
+	}
+	return nil
+}
+
+func (d *Driver) checkHourlyCost(serverType *brightbox.ServerType) error {
+	if d.PriceList == "" {
+		return nil
+	}
+	prices, err := readPriceList(d.PriceList)
+	if err != nil {
+		return err
+	}
+	price, ok := prices[serverType.Handle]
+	if !ok {
+		return fmt.Errorf("No price for server type %s in %s", serverType.Handle, d.PriceList)
+	}
+	log.Infof("Server type %s costs %.4f per hour", serverType.Handle, price)
+	if d.MaxHourlyCost > 0 && price > d.MaxHourlyCost {
+		return fmt.Errorf("Server type %s costs %.4f per hour, more than the maximum of %.4f", serverType.Handle, price, d.MaxHourlyCost)
+	}
+	return nil
+}
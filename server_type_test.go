@@ -0,0 +1,63 @@
+package brightbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brightbox/gobrightbox"
+)
+
+var testServerTypes = []brightbox.ServerType{
+	{Resource: brightbox.Resource{Id: "typ-zx45f"}, Handle: "1gb.ssd", Ram: 1024},
+	{Resource: brightbox.Resource{Id: "typ-8fych"}, Handle: "4gb.ssd", Ram: 4096},
+}
+
+func TestFindServerType(t *testing.T) {
+	for _, name := range []string{"4gb.ssd", "typ-8fych"} {
+		serverType, err := findServerType(testServerTypes, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serverType.Id != "typ-8fych" {
+			t.Errorf("Incorrect server type for %s: %s", name, serverType.Id)
+		}
+	}
+	if _, err := findServerType(testServerTypes, "64gb.ssd"); err == nil {
+		t.Error("Unknown server type not detected")
+	}
+}
+
+func TestCheckRAMLimit(t *testing.T) {
+	account := &brightbox.Account{RamLimit: 8192, RamUsed: 6144}
+	if err := checkRAMLimit(account, &testServerTypes[0]); err != nil {
+		t.Errorf("Server within limit rejected: %s", err)
+	}
+	if err := checkRAMLimit(account, &testServerTypes[1]); err == nil {
+		t.Error("Server over limit accepted")
+	}
+}
+
+func TestCheckHourlyCost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver := new(Driver)
+	driver.PriceList = filepath.Join(dir, "prices.json")
+	if err := ioutil.WriteFile(driver.PriceList, []byte(`{"1gb.ssd": 0.02, "4gb.ssd": 0.08}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	driver.MaxHourlyCost = 0.05
+	if err := driver.checkHourlyCost(&testServerTypes[0]); err != nil {
+		t.Errorf("Server within cost rejected: %s", err)
+	}
+	if err := driver.checkHourlyCost(&testServerTypes[1]); err == nil {
+		t.Error("Server over cost accepted")
+	}
+	if err := driver.checkHourlyCost(&brightbox.ServerType{Handle: "8gb.ssd"}); err == nil {
+		t.Error("Server type without price accepted")
+	}
+}
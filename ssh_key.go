@@ -0,0 +1,51 @@
+package brightbox
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// readPublicKey returns the public half of the private key at path in
+// authorized_keys format. It is always derived from the private key.
+// The matching '.pub' file is used for its comment if there is one,
+// but only if it holds the same key: a stale one would give a server
+// docker-machine can't log in to.
+func readPublicKey(path string) ([]byte, error) {
+	privatekey, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := derivePublicKey(privatekey)
+	if err != nil {
+		return nil, err
+	}
+	publickey, err := ioutil.ReadFile(path + ".pub")
+	switch {
+	case os.IsNotExist(err):
+		log.Debugf("No public key file for %s. Deriving from private key", path)
+		return derived, nil
+	case err != nil:
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(publickey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read public key %s.pub: %s", path, err)
+	}
+	if !bytes.Equal(ssh.MarshalAuthorizedKey(key), derived) {
+		return nil, fmt.Errorf("Public key %s.pub does not match private key %s", path, path)
+	}
+	return publickey, nil
+}
+
+func derivePublicKey(privatekey []byte) ([]byte, error) {
+	signer, err := ssh.ParsePrivateKey(privatekey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to derive public key from private key: %s", err)
+	}
+	return ssh.MarshalAuthorizedKey(signer.PublicKey()), nil
+}
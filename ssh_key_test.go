@@ -0,0 +1,96 @@
+package brightbox
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func writeTestPrivateKey(t *testing.T, dir string) (string, ssh.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+	path := filepath.Join(dir, "id_rsa")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	public, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, public
+}
+
+func TestReadPublicKeyDerived(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, public := writeTestPrivateKey(t, dir)
+	result, err := readPublicKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != string(ssh.MarshalAuthorizedKey(public)) {
+		t.Errorf("Incorrect derived public key: %s", result)
+	}
+}
+
+func TestReadPublicKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, public := writeTestPrivateKey(t, dir)
+	expected := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public))) + " test@example\n"
+	if err := ioutil.WriteFile(path+".pub", []byte(expected), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := readPublicKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != expected {
+		t.Errorf("Public key file not used: %s", result)
+	}
+}
+
+func TestReadPublicKeyFileMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, _ := writeTestPrivateKey(t, dir)
+	_, stale, err := generateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".pub", stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPublicKey(path); err == nil {
+		t.Error("Stale public key file used")
+	}
+}
+
+func TestReadPublicKeyInvalid(t *testing.T) {
+	if _, err := derivePublicKey([]byte("not a key")); err == nil {
+		t.Error("Invalid private key not detected")
+	}
+}
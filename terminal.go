@@ -0,0 +1,52 @@
+package brightbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+var errNoTerminal = errors.New("No terminal available to prompt on")
+
+// openTerminal opens the controlling terminal directly, as the plugin's
+// own stdin and stdout belong to docker-machine.
+func openTerminal() (in *os.File, out *os.File, err error) {
+	if runtime.GOOS == "windows" {
+		in, err = os.Open("CONIN$")
+		if err != nil {
+			return nil, nil, errNoTerminal
+		}
+		out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+		if err != nil {
+			in.Close()
+			return nil, nil, errNoTerminal
+		}
+		return in, out, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, errNoTerminal
+	}
+	return tty, tty, nil
+}
+
+// promptLine asks a question on the terminal and returns the answer.
+func promptLine(question string) (string, error) {
+	in, out, err := openTerminal()
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+	fmt.Fprint(out, question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
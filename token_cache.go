@@ -0,0 +1,145 @@
+package brightbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/oauth2"
+)
+
+const tokenCacheFileName = "brightbox_tokens.json"
+
+// tokenCache is a file in the docker-machine store that lets every
+// plugin process share one token per set of credentials, rather than
+// each authenticating for itself.
+type tokenCache struct {
+	path   string
+	key    string
+	hold   time.Duration
+	unlock func()
+}
+
+// tokenCacheKey identifies the credentials a token was issued for. The
+// secrets are included as a hash, so a token isn't reused once they
+// change, without them being written to the cache.
+func tokenCacheKey(apiURL, clientID, account, userName, clientSecret, password string) string {
+	secrets := sha256.Sum256([]byte(clientSecret + "\x00" + password))
+	return strings.Join([]string{apiURL, clientID, account, userName, hex.EncodeToString(secrets[:])}, " ")
+}
+
+// sharedTokenCache returns the cache for these credentials, or nil if
+// there is no store to keep it in. Renewing a token can take a refresh
+// and two token requests while the cache is locked.
+func (authd *authdetails) sharedTokenCache() *tokenCache {
+	if authd.tokenCacheDir == "" {
+		return nil
+	}
+	key := tokenCacheKey(authd.APIURL, authd.clientID(), authd.Account, authd.userName(),
+		authd.clientSecret(), authd.userPassword())
+	return &tokenCache{
+		path: filepath.Join(authd.tokenCacheDir, tokenCacheFileName),
+		key:  key,
+		hold: 3 * authd.apiTimeout(),
+	}
+}
+
+// renew returns a token from the cache, unless it is the one being
+// replaced or is close to expiry, in which case it calls fetch and
+// caches the result. The cache stays locked while fetch runs, so
+// plugins that need a token at the same time wait for the one fetching
+// it and then share its token. fetch releases the lock with unlocked
+// while it waits for a one time password.
+func (c *tokenCache) renew(current *oauth2.Token, fetch func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	unlock, err := lockFile(c.path+".lock", c.hold)
+	if err != nil {
+		log.Debugf("Token cache unavailable: %s", err)
+		return fetch()
+	}
+	c.unlock = unlock
+	defer c.release()
+	if cached := c.read()[c.key]; cached != nil &&
+		(current == nil || cached.AccessToken != current.AccessToken) {
+		log.Debug("Using Brightbox API token from the token cache")
+		return cached, nil
+	}
+	token, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if c.unlock == nil {
+		log.Debug("Token cache unavailable. Not caching token")
+		return token, nil
+	}
+	tokens := c.read()
+	tokens[c.key] = token
+	if err := c.write(tokens); err != nil {
+		log.Debugf("Unable to update token cache: %s", err)
+	}
+	return token, nil
+}
+
+// unlocked runs wait with the cache unlocked, so other plugins aren't
+// held up while the user is asked for something, and locks it again
+// afterwards.
+func (c *tokenCache) unlocked(wait func()) {
+	if c == nil || c.unlock == nil {
+		wait()
+		return
+	}
+	c.release()
+	wait()
+	unlock, err := lockFile(c.path+".lock", c.hold)
+	if err != nil {
+		log.Debugf("Token cache unavailable: %s", err)
+		return
+	}
+	c.unlock = unlock
+}
+
+// release unlocks the cache if it is locked
+func (c *tokenCache) release() {
+	if c.unlock != nil {
+		c.unlock()
+		c.unlock = nil
+	}
+}
+
+// read returns the unexpired tokens in the cache. A missing or corrupt
+// cache is treated as empty.
+func (c *tokenCache) read() map[string]*oauth2.Token {
+	tokens := make(map[string]*oauth2.Token)
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return tokens
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		log.Debugf("Ignoring unreadable token cache: %s", err)
+		return make(map[string]*oauth2.Token)
+	}
+	for key, token := range tokens {
+		if token == nil || nearExpiry(token) {
+			delete(tokens, key)
+		}
+	}
+	return tokens
+}
+
+// write replaces the cache file, readable only by its owner
+func (c *tokenCache) write(tokens map[string]*oauth2.Token) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	temp := c.path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, c.path)
+}
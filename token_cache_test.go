@@ -0,0 +1,204 @@
+package brightbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func testTokenCache(t *testing.T) (*tokenCache, func()) {
+	dir, err := ioutil.TempDir("", "brightbox-token-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &tokenCache{
+		path: filepath.Join(dir, tokenCacheFileName),
+		key:  tokenCacheKey("https://api.gb1.brightbox.com", "cli-12345", "acc-12345", "", "abcdefg", ""),
+	}
+	return cache, func() { os.RemoveAll(dir) }
+}
+
+// countingFetch returns a new token each time it is called
+func countingFetch(count *int) func() (*oauth2.Token, error) {
+	return func() (*oauth2.Token, error) {
+		*count++
+		return &oauth2.Token{
+			AccessToken: fmt.Sprintf("token%d", *count),
+			Expiry:      time.Now().Add(time.Hour),
+		}, nil
+	}
+}
+
+func TestTokenCacheShared(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	var fetches int
+	first, err := cache.renew(nil, countingFetch(&fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second process with the same credentials
+	other := &tokenCache{path: cache.path, key: cache.key}
+	second, err := other.renew(nil, countingFetch(&fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 || second.AccessToken != first.AccessToken {
+		t.Errorf("Cached token not shared: %d fetches, %s and %s", fetches, first.AccessToken, second.AccessToken)
+	}
+	info, err := os.Stat(cache.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Token cache has mode %s", info.Mode().Perm())
+	}
+}
+
+func TestTokenCacheReplacesRejectedToken(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	var fetches int
+	first, err := cache.renew(nil, countingFetch(&fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cache.renew(first, countingFetch(&fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 || second.AccessToken == first.AccessToken {
+		t.Errorf("Rejected token returned from cache")
+	}
+}
+
+func TestTokenCacheSeparatesCredentials(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	var fetches int
+	if _, err := cache.renew(nil, countingFetch(&fetches)); err != nil {
+		t.Fatal(err)
+	}
+	other := &tokenCache{
+		path: cache.path,
+		key:  tokenCacheKey("https://api.gb1.brightbox.com", "cli-12345", "acc-67890", "", "abcdefg", ""),
+	}
+	if _, err := other.renew(nil, countingFetch(&fetches)); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Errorf("Token shared between accounts")
+	}
+}
+
+func TestTokenCacheSeparatesSecrets(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	var fetches int
+	if _, err := cache.renew(nil, countingFetch(&fetches)); err != nil {
+		t.Fatal(err)
+	}
+	rotated := &tokenCache{
+		path: cache.path,
+		key:  tokenCacheKey("https://api.gb1.brightbox.com", "cli-12345", "acc-12345", "", "rotated", ""),
+	}
+	if _, err := rotated.renew(nil, countingFetch(&fetches)); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Errorf("Token reused after the secret changed")
+	}
+	data, err := ioutil.ReadFile(cache.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "abcdefg") || strings.Contains(string(data), "rotated") {
+		t.Errorf("Secret written to token cache: %s", data)
+	}
+}
+
+func TestTokenCacheConcurrentRenewals(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	var mu sync.Mutex
+	var fetches int
+	fetch := func() (*oauth2.Token, error) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each renewal stands for a separate plugin process
+			other := &tokenCache{path: cache.path, key: cache.key}
+			if token, err := other.renew(nil, fetch); err != nil || token.AccessToken != "token" {
+				t.Errorf("Renewal failed: %v, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("Concurrent renewals made %d token requests", fetches)
+	}
+}
+
+func TestTokenCacheUnlockedForPrompt(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	lock := cache.path + ".lock"
+	fetch := func() (*oauth2.Token, error) {
+		if _, err := os.Stat(lock); err != nil {
+			t.Error("Token cache not locked during fetch")
+		}
+		cache.unlocked(func() {
+			if _, err := os.Stat(lock); err == nil {
+				t.Error("Token cache locked during prompt")
+			}
+		})
+		return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+	}
+	if _, err := cache.renew(nil, fetch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Error("Token cache left locked")
+	}
+	other := &tokenCache{path: cache.path, key: cache.key}
+	var fetches int
+	if cached, err := other.renew(nil, countingFetch(&fetches)); err != nil || cached.AccessToken != "token" {
+		t.Errorf("Token not cached: %v, %v", cached, err)
+	}
+}
+
+func TestTokenCacheStaleLock(t *testing.T) {
+	cache, cleanup := testTokenCache(t)
+	defer cleanup()
+	lock := cache.path + ".lock"
+	if err := ioutil.WriteFile(lock, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * fileLockStale)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockFile(lock, 0)
+	if err != nil {
+		t.Fatalf("Stale lock not removed: %s", err)
+	}
+	unlock()
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Error("Lock not released")
+	}
+}
@@ -0,0 +1,130 @@
+package brightbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/oauth2"
+)
+
+// Tokens are renewed this long before they are due to expire, so
+// they don't run out part way through a call.
+const tokenExpiryMargin = time.Minute
+
+// renewingTokenSource hands out the current token. When the token is
+// close to expiry, or the API has rejected it, it tries the refresh
+// token and then falls back to authenticating from scratch. With a
+// shared cache, tokens obtained by other plugin processes are used
+// first.
+type renewingTokenSource struct {
+	mu      sync.Mutex
+	token   *oauth2.Token
+	stale   bool
+	refresh func(*oauth2.Token) (*oauth2.Token, error)
+	acquire func() (*oauth2.Token, error)
+	update  func(*oauth2.Token)
+	shared  *tokenCache
+}
+
+func (s *renewingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && !s.stale && !nearExpiry(s.token) {
+		return s.token, nil
+	}
+	var token *oauth2.Token
+	var err error
+	if s.shared != nil {
+		token, err = s.shared.renew(s.token, s.renew)
+	} else {
+		token, err = s.renew()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	s.stale = false
+	if s.update != nil {
+		s.update(token)
+	}
+	return token, nil
+}
+
+func (s *renewingTokenSource) renew() (*oauth2.Token, error) {
+	if s.token != nil && s.token.RefreshToken != "" && s.refresh != nil {
+		log.Debug("Refreshing Brightbox API token")
+		token, err := s.refresh(s.token)
+		if err == nil {
+			return token, nil
+		}
+		log.Debugf("Token refresh failed: %s", err)
+	}
+	log.Debug("Obtaining new Brightbox API token")
+	return s.acquire()
+}
+
+// invalidate makes the next call to Token obtain a new token
+func (s *renewingTokenSource) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stale = true
+}
+
+func nearExpiry(token *oauth2.Token) bool {
+	return !token.Expiry.IsZero() && time.Now().Add(tokenExpiryMargin).After(token.Expiry)
+}
+
+// reauthTransport retries a request once with a new token if the API
+// says the current one is unauthorized.
+type reauthTransport struct {
+	source *renewingTokenSource
+	base   http.RoundTripper
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := t.base.RoundTrip(withBody(req, body))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	log.Debug("Brightbox API rejected token. Authenticating again")
+	res.Body.Close()
+	t.source.invalidate()
+	return t.base.RoundTrip(withBody(req, body))
+}
+
+// withBody copies req with a fresh reader over body, leaving the
+// caller's request as it was.
+func withBody(req *http.Request, body []byte) *http.Request {
+	clone := *req
+	if req.Body != nil {
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return &clone
+}
+
+// renewingClient returns an HTTP client that authorizes requests with
+// tokens from source, sending them over base.
+func renewingClient(source *renewingTokenSource, base http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: &reauthTransport{
+			source: source,
+			base: &oauth2.Transport{
+				Source: source,
+				Base:   base,
+			},
+		},
+	}
+}
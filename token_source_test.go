@@ -0,0 +1,173 @@
+package brightbox
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// testAPI issues numbered tokens and rejects any token it has been
+// told to revoke.
+type testAPI struct {
+	mu        sync.Mutex
+	issued    int
+	expiresIn int
+	grants    []string
+	revoked   map[string]bool
+}
+
+func (api *testAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	switch r.URL.Path {
+	case "/token":
+		r.ParseForm()
+		api.grants = append(api.grants, r.Form.Get("grant_type"))
+		api.issued++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","refresh_token":"refresh-%d","token_type":"Bearer","expires_in":%d}`,
+			api.issued, api.issued, api.expiresIn)
+	default:
+		token := r.Header.Get("Authorization")
+		if api.revoked[token] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, token)
+	}
+}
+
+func (api *testAPI) revoke(token string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.revoked["Bearer "+token] = true
+}
+
+func newTestAPI(expiresIn int) (*testAPI, *httptest.Server) {
+	api := &testAPI{expiresIn: expiresIn, revoked: make(map[string]bool)}
+	return api, httptest.NewServer(api)
+}
+
+func getTestAuthorization(t *testing.T, client *http.Client, url string) string {
+	res, err := client.Get(url + "/1.0/account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %s", res.Status)
+	}
+	var authorization string
+	fmt.Fscan(res.Body, &authorization, &authorization)
+	return authorization
+}
+
+func TestClientCredentialsReauthOnUnauthorized(t *testing.T) {
+	api, server := newTestAPI(3600)
+	defer server.Close()
+	authd := &authdetails{APIClient: "cli-12345", APISecret: "abcdefg", APIURL: server.URL}
+	client := renewingClient(authd.clientCredentialsTokenSource(oauth2.NoContext), http.DefaultTransport)
+	if token := getTestAuthorization(t, client, server.URL); token != "token-1" {
+		t.Fatalf("Unexpected first token %s", token)
+	}
+	api.revoke("token-1")
+	if token := getTestAuthorization(t, client, server.URL); token != "token-2" {
+		t.Errorf("Rejected token not replaced: %s", token)
+	}
+	if authd.currentToken.AccessToken != "token-2" {
+		t.Errorf("Current token not updated: %s", authd.currentToken.AccessToken)
+	}
+}
+
+func TestPasswordTokenRefreshNearExpiry(t *testing.T) {
+	api, server := newTestAPI(30)
+	defer server.Close()
+	authd := &authdetails{APIClient: "app-12345", APIURL: server.URL, UserName: "fred", password: "hunter2"}
+	source := authd.passwordTokenSource(oauth2.NoContext)
+	client := renewingClient(source, http.DefaultTransport)
+	getTestAuthorization(t, client, server.URL)
+	token := getTestAuthorization(t, client, server.URL)
+	if token != "token-2" {
+		t.Errorf("Token near expiry not renewed: %s", token)
+	}
+	if len(api.grants) != 2 || api.grants[0] != "password" || api.grants[1] != "refresh_token" {
+		t.Errorf("Unexpected grants: %v", api.grants)
+	}
+}
+
+func TestPasswordTokenReacquiredWhenRejected(t *testing.T) {
+	api, server := newTestAPI(3600)
+	defer server.Close()
+	authd := &authdetails{APIClient: "app-12345", APIURL: server.URL, UserName: "fred", password: "hunter2"}
+	authd.currentToken = &oauth2.Token{AccessToken: "stale", TokenType: "Bearer"}
+	source := authd.passwordTokenSource(oauth2.NoContext)
+	client := renewingClient(source, http.DefaultTransport)
+	api.revoke("stale")
+	if token := getTestAuthorization(t, client, server.URL); token != "token-1" {
+		t.Errorf("Rejected token not replaced: %s", token)
+	}
+	if len(api.grants) != 1 || api.grants[0] != "password" {
+		t.Errorf("Unexpected grants: %v", api.grants)
+	}
+}
+
+func TestClientCredentialsKeepGrantWithCurrentToken(t *testing.T) {
+	api, server := newTestAPI(3600)
+	defer server.Close()
+	authd := &authdetails{APIClient: "cli-12345", APISecret: "abcdefg", APIURL: server.URL}
+	authd.currentToken = &oauth2.Token{
+		AccessToken:  "token-0",
+		RefreshToken: "refresh-0",
+		Expiry:       time.Now().Add(-time.Minute),
+	}
+	if _, err := authd.authenticatedClient(); err != nil {
+		t.Fatal(err)
+	}
+	source := authd.clientCredentialsTokenSource(oauth2.NoContext)
+	source.invalidate()
+	if _, err := source.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.grants) != 1 || api.grants[0] != "client_credentials" {
+		t.Errorf("Unexpected grants: %v", api.grants)
+	}
+}
+
+// recordingBody notes whether the transport replaced it
+type recordingBody struct {
+	io.Reader
+}
+
+func (b *recordingBody) Close() error { return nil }
+
+func TestReauthTransportLeavesRequest(t *testing.T) {
+	api, server := newTestAPI(3600)
+	defer server.Close()
+	authd := &authdetails{APIClient: "cli-12345", APISecret: "abcdefg", APIURL: server.URL}
+	client := renewingClient(authd.clientCredentialsTokenSource(oauth2.NoContext), http.DefaultTransport)
+	getTestAuthorization(t, client, server.URL)
+	api.revoke("token-1")
+	body := &recordingBody{Reader: strings.NewReader("name=test")}
+	req, err := http.NewRequest("POST", server.URL+"/1.0/servers", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Request not retried: %s", res.Status)
+	}
+	if req.Body != body {
+		t.Error("Caller's request body replaced")
+	}
+}
@@ -0,0 +1,93 @@
+package brightbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// tracingTransport logs a structured line for every API call and,
+// if dump is set, writes the redacted request and response bodies to
+// it.
+type tracingTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex //guards dump
+	dump io.Writer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if t.dump != nil && req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = body
+		req = withBody(req, body)
+	}
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+	if err != nil {
+		log.Infof("brightbox-api method=%s path=%s latency=%s error=%q",
+			req.Method, req.URL.Path, latency, redactText(err.Error()))
+		return nil, err
+	}
+	requestID := res.Header.Get(requestIDHeader)
+	log.Infof("brightbox-api method=%s path=%s status=%d latency=%s request_id=%s",
+		req.Method, req.URL.Path, res.StatusCode, latency, requestID)
+	if t.dump != nil {
+		resBody, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+		t.writeDump(req, reqBody, res, resBody, latency)
+	}
+	return res, nil
+}
+
+func (t *tracingTransport) writeDump(req *http.Request, reqBody []byte, res *http.Response, resBody []byte, latency time.Duration) {
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "%s > %s %s\n", time.Now().UTC().Format(time.RFC3339), req.Method, req.URL.Path)
+	if len(reqBody) > 0 {
+		fmt.Fprintf(&entry, "%s\n", redactBody(reqBody))
+	}
+	fmt.Fprintf(&entry, "%s < %s (%s) request_id=%s\n", time.Now().UTC().Format(time.RFC3339), res.Status, latency, res.Header.Get(requestIDHeader))
+	if len(resBody) > 0 {
+		fmt.Fprintf(&entry, "%s\n", redactBody(resBody))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.dump.Write(entry.Bytes()); err != nil {
+		log.Debugf("Unable to write API trace: %s", err)
+	}
+}
+
+// appendFile is a file that each write is appended to. The file is
+// only open while it is being written, so nothing is left open for the
+// life of the plugin.
+type appendFile string
+
+func (path appendFile) Write(data []byte) (int, error) {
+	file, err := os.OpenFile(string(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
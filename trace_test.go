@@ -0,0 +1,88 @@
+package brightbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTracingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(requestIDHeader, "req-12345")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tokentoken","token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+	var dump bytes.Buffer
+	client := &http.Client{
+		Transport: &tracingTransport{
+			base: http.DefaultTransport,
+			dump: &dump,
+		},
+	}
+	form := url.Values{"grant_type": {"password"}, "username": {"testuser"}, "password": {"secret"}}
+	res, err := client.PostForm(server.URL+"/token", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "tokentoken") {
+		t.Errorf("Response body not passed through: %s", body)
+	}
+	result := dump.String()
+	if !strings.Contains(result, "> POST /token") || !strings.Contains(result, "request_id=req-12345") {
+		t.Errorf("Call missing from dump: %s", result)
+	}
+	if strings.Contains(result, "tokentoken") || strings.Contains(result, "secret") {
+		t.Errorf("Secrets not redacted in dump: %s", result)
+	}
+	if !strings.Contains(result, "username=testuser") {
+		t.Errorf("Request body missing from dump: %s", result)
+	}
+}
+
+func TestTraceFileNotHeldOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.log")
+	authd := &authdetails{Trace: true, TraceFile: path}
+	openFiles := func() int {
+		fds, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("Open files can't be counted here")
+		}
+		return len(fds)
+	}
+	before := openFiles()
+	for i := 0; i < 20; i++ {
+		if _, err := authd.apiContext(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := openFiles(); after > before {
+		t.Errorf("Trace file left open: %d files open before, %d after", before, after)
+	}
+	dump := appendFile(path)
+	dump.Write([]byte("first\n"))
+	dump.Write([]byte("second\n"))
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "first\nsecond\n" {
+		t.Errorf("Trace not appended: %q", content)
+	}
+}
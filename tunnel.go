@@ -0,0 +1,413 @@
+package brightbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	tunnelStateFile = "tunnel.json"
+	tunnelLogFile   = "tunnel.log"
+
+	// tunnelCommand is the argument that runs the plugin binary as a
+	// forwarder rather than as a docker-machine plugin.
+	tunnelCommand = "tunnel"
+)
+
+// How long to wait for a newly started forwarder to listen
+var tunnelStartTimeout = 10 * time.Second
+
+// tunnelSpec is everything a forwarder needs to carry connections from
+// local ports to the server, through the bastion if there is one. With
+// a pinned host key the forwarder logs in to the server itself, checks
+// the key and forwards to ports on the server's loopback address.
+type tunnelSpec struct {
+	Bastion        string
+	BastionKey     string
+	BastionHostKey string
+	Target         string
+	TargetPort     int
+	TargetUser     string
+	TargetKey      string
+	TargetHostKey  string
+	Forwards       []portForward
+	StateFile      string
+}
+
+// tunnelState is written by a forwarder once it is listening. It names
+// the process, so a forwarder for another machine that happens to hold
+// the same port isn't mistaken for this machine's, and gives the ports
+// actually in use.
+type tunnelState struct {
+	Pid      int
+	Forwards []portForward
+}
+
+// portForward sends connections to a local port on to a port on the
+// server.
+type portForward struct {
+	Local  int
+	Remote int
+}
+
+// freePort returns a local port that nothing is listening on
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// useTunnel reports whether the machine is reached through a
+// forwarder: always with a bastion, and for SSH when the server's
+// host key is to be checked strictly.
+func (d *Driver) useTunnel() bool {
+	return d.Bastion != "" || d.StrictHostKey
+}
+
+// allocateTunnelPorts picks the local ports the forwarder listens on.
+// They are saved with the machine, so the addresses docker-machine
+// hands out stay valid from one command to the next. Docker is only
+// forwarded through a bastion: otherwise it is reached directly, over
+// TLS.
+func (d *Driver) allocateTunnelPorts() error {
+	var err error
+	if d.TunnelSSHPort == 0 {
+		if d.TunnelSSHPort, err = freePort(); err != nil {
+			return err
+		}
+	}
+	if d.TunnelDockerPort == 0 && d.Bastion != "" {
+		if d.TunnelDockerPort, err = freePort(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) tunnelSpec() (*tunnelSpec, error) {
+	spec := &tunnelSpec{
+		Forwards:  []portForward{{Local: d.TunnelSSHPort, Remote: d.SSHPort}},
+		StateFile: d.ResolveStorePath(tunnelStateFile),
+	}
+	if d.HostKeyPinned {
+		if _, err := readPinnedHostKey(d.hostKeyPath()); err != nil {
+			return nil, err
+		}
+		spec.TargetPort = d.SSHPort
+		spec.TargetUser = d.GetSSHUsername()
+		spec.TargetKey = d.GetSSHKeyPath()
+		spec.TargetHostKey = d.hostKeyPath()
+	}
+	if d.Bastion != "" {
+		spec.Bastion = d.Bastion
+		spec.BastionKey = d.bastionKeyPath()
+		spec.BastionHostKey = d.ResolveStorePath(bastionHostKeyFile)
+		spec.Forwards = append(spec.Forwards, portForward{Local: d.TunnelDockerPort, Remote: dockerPort})
+	}
+	ip, err := d.GetIP()
+	if err != nil {
+		return nil, err
+	}
+	spec.Target = ip
+	return spec, nil
+}
+
+// ensureTunnel makes sure the machine's forwarder is running, starting
+// it in the background if need be, and takes the ports it listens on.
+// The forwarder outlives the docker-machine command that started it, so
+// the Docker URL keeps working until the machine is removed.
+func (d *Driver) ensureTunnel() error {
+	if err := d.allocateTunnelPorts(); err != nil {
+		return err
+	}
+	state := d.runningTunnel()
+	if state == nil {
+		spec, err := d.tunnelSpec()
+		if err != nil {
+			return err
+		}
+		if state, err = d.startTunnel(spec); err != nil {
+			return err
+		}
+	}
+	d.TunnelSSHPort = state.Forwards[0].Local
+	if len(state.Forwards) > 1 {
+		d.TunnelDockerPort = state.Forwards[1].Local
+	}
+	return nil
+}
+
+func (d *Driver) readTunnelState() (*tunnelState, error) {
+	data, err := ioutil.ReadFile(d.ResolveStorePath(tunnelStateFile))
+	if err != nil {
+		return nil, err
+	}
+	var state tunnelState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if len(state.Forwards) == 0 {
+		return nil, fmt.Errorf("No ports in forwarder state")
+	}
+	return &state, nil
+}
+
+// runningTunnel returns the state of the machine's forwarder if that
+// process is still alive. It binds its ports before recording them, so
+// while it lives the ports are its own.
+func (d *Driver) runningTunnel() *tunnelState {
+	state, err := d.readTunnelState()
+	if err != nil || !processAlive(state.Pid) {
+		return nil
+	}
+	return state
+}
+
+// startTunnel runs the plugin binary again as a detached forwarder,
+// passing it the spec on stdin, and waits for that process to record
+// that it is listening.
+func (d *Driver) startTunnel(spec *tunnelSpec) (*tunnelState, error) {
+	logPath := d.ResolveStorePath(tunnelLogFile)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	os.Remove(spec.StateFile)
+	cmd := exec.Command(os.Args[0], tunnelCommand)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcess()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Starting forwarder for %s", spec.Target)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Unable to start forwarder: %s", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	err = json.NewEncoder(stdin).Encode(spec)
+	stdin.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	deadline := time.After(tunnelStartTimeout)
+	for {
+		if state, err := d.readTunnelState(); err == nil && state.Pid == cmd.Process.Pid {
+			return state, nil
+		}
+		select {
+		case <-exited:
+			return nil, fmt.Errorf("Forwarder exited. See %s", logPath)
+		case <-deadline:
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("Forwarder did not start. See %s", logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// stopTunnel stops the machine's forwarder, if it is running
+func (d *Driver) stopTunnel() {
+	state := d.runningTunnel()
+	os.Remove(d.ResolveStorePath(tunnelStateFile))
+	if state == nil {
+		return
+	}
+	if process, err := os.FindProcess(state.Pid); err == nil {
+		log.Debugf("Stopping forwarder %d", state.Pid)
+		process.Kill()
+	}
+}
+
+// listenForwards listens on the local port of each forward. A port
+// taken since it was allocated, perhaps by another machine's forwarder,
+// is replaced by a free one. The forwards are returned with the ports
+// actually used.
+func listenForwards(forwards []portForward) ([]net.Listener, []portForward, error) {
+	listeners := make([]net.Listener, len(forwards))
+	actual := make([]portForward, len(forwards))
+	for i, forward := range forwards {
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(forward.Local)))
+		if err != nil {
+			log.Infof("Local port %d unavailable, using another: %s", forward.Local, err)
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+		}
+		if err != nil {
+			for _, opened := range listeners[:i] {
+				opened.Close()
+			}
+			return nil, nil, err
+		}
+		listeners[i] = listener
+		actual[i] = portForward{Local: listener.Addr().(*net.TCPAddr).Port, Remote: forward.Remote}
+	}
+	return listeners, actual, nil
+}
+
+// writeTunnelState replaces the state file in one step, so the driver
+// never reads it half written.
+func writeTunnelState(path string, state *tunnelState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// RunTunnel runs a forwarder with the spec read from input. It is
+// started by the driver and runs until it is killed.
+func RunTunnel(input io.Reader) error {
+	var spec tunnelSpec
+	if err := json.NewDecoder(input).Decode(&spec); err != nil {
+		return err
+	}
+	f := &forwarder{spec: &spec}
+	listeners, forwards, err := listenForwards(spec.Forwards)
+	if err != nil {
+		return err
+	}
+	if err := writeTunnelState(spec.StateFile, &tunnelState{Pid: os.Getpid(), Forwards: forwards}); err != nil {
+		return err
+	}
+	defer os.Remove(spec.StateFile)
+	var wg sync.WaitGroup
+	for i, forward := range forwards {
+		wg.Add(1)
+		go func(listener net.Listener, remote int) {
+			defer wg.Done()
+			f.serve(listener, remote)
+		}(listeners[i], forward.Remote)
+	}
+	wg.Wait()
+	return nil
+}
+
+// forwarder holds the SSH connection that forwarded connections go
+// through, making it again if it drops. That is the connection to the
+// server when its host key is pinned, otherwise to the bastion.
+type forwarder struct {
+	spec    *tunnelSpec
+	mu      sync.Mutex
+	bastion *ssh.Client
+	conn    *ssh.Client
+}
+
+func (f *forwarder) client() (*ssh.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		return f.conn, nil
+	}
+	if f.spec.Bastion != "" && f.bastion == nil {
+		bastion, err := dialBastion(f.spec.Bastion, f.spec.BastionKey, f.spec.BastionHostKey)
+		if err != nil {
+			return nil, err
+		}
+		f.bastion = bastion
+	}
+	if f.spec.TargetHostKey == "" {
+		f.conn = f.bastion
+		return f.conn, nil
+	}
+	address := net.JoinHostPort(f.spec.Target, strconv.Itoa(f.spec.TargetPort))
+	conn, err := dialServer(f.bastion, address, f.spec.TargetUser, f.spec.TargetKey, f.spec.TargetHostKey)
+	if err != nil {
+		if f.bastion != nil {
+			// The bastion may be the reason the server can't be reached
+			f.bastion.Close()
+			f.bastion = nil
+		}
+		return nil, err
+	}
+	f.conn = conn
+	return f.conn, nil
+}
+
+// reset drops a broken connection, along with the bastion connection
+// beneath it.
+func (f *forwarder) reset(broken *ssh.Client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != broken {
+		return
+	}
+	f.conn.Close()
+	if f.bastion != nil && f.bastion != f.conn {
+		f.bastion.Close()
+	}
+	f.conn = nil
+	f.bastion = nil
+}
+
+// dial connects to port on the server, reconnecting once if the
+// existing connection has failed. Logged in to the server, the port is
+// reached on its loopback address.
+func (f *forwarder) dial(port int) (net.Conn, error) {
+	host := f.spec.Target
+	if f.spec.TargetHostKey != "" {
+		host = "127.0.0.1"
+	}
+	remote := net.JoinHostPort(host, strconv.Itoa(port))
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var client *ssh.Client
+		client, err = f.client()
+		if err != nil {
+			continue
+		}
+		var conn net.Conn
+		conn, err = client.Dial("tcp", remote)
+		if err == nil {
+			return conn, nil
+		}
+		log.Debugf("Unable to reach %s: %s", remote, err)
+		f.reset(client)
+	}
+	return nil, err
+}
+
+func (f *forwarder) serve(listener net.Listener, remote int) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			log.Errorf("Forwarder stopped: %s", err)
+			return
+		}
+		go func() {
+			defer local.Close()
+			conn, err := f.dial(remote)
+			if err != nil {
+				log.Errorf("Unable to forward to port %d: %s", remote, err)
+				return
+			}
+			defer conn.Close()
+			go io.Copy(conn, local)
+			io.Copy(local, conn)
+		}()
+	}
+}
@@ -0,0 +1,336 @@
+package brightbox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer accepts any public key and forwards direct-tcpip
+// channels, as a bastion does.
+type testSSHServer struct {
+	listener net.Listener
+	hostKey  ssh.PublicKey
+	mu       sync.Mutex
+	conns    []*ssh.ServerConn
+	logins   int
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	hostKey, _, err := generateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testSSHServer{listener: listener, hostKey: signer.PublicKey()}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s *testSSHServer) handle(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, serverConn)
+	s.logins++
+	s.mu.Unlock()
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			defer channel.Close()
+			defer remote.Close()
+			go io.Copy(remote, channel)
+			io.Copy(channel, remote)
+		}()
+	}
+}
+
+// drop closes every connection, as a network failure would
+func (s *testSSHServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) loginCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// echoServer answers each line with the same line
+func echoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func roundTrip(t *testing.T, address, message string) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, message)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("No reply through forwarder: %s", err)
+	}
+	if reply != message+"\n" {
+		t.Errorf("Incorrect reply through forwarder: %q", reply)
+	}
+}
+
+func TestForwarderReconnects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath, _ := writeTestPrivateKey(t, dir)
+	bastion := newTestSSHServer(t)
+	defer bastion.listener.Close()
+	echo := echoServer(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	remote, _ := strconv.Atoi(echoPort)
+
+	f := &forwarder{spec: &tunnelSpec{
+		Bastion:        "jump@" + bastion.listener.Addr().String(),
+		BastionKey:     keyPath,
+		BastionHostKey: filepath.Join(dir, bastionHostKeyFile),
+		Target:         "127.0.0.1",
+	}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go f.serve(listener, remote)
+
+	roundTrip(t, listener.Addr().String(), "first")
+	bastion.drop()
+	roundTrip(t, listener.Addr().String(), "second")
+	if bastion.loginCount() != 2 {
+		t.Errorf("Expected a reconnection to the bastion, got %d logins", bastion.loginCount())
+	}
+}
+
+func TestForwarderPinnedServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath, _ := writeTestPrivateKey(t, dir)
+	bastion := newTestSSHServer(t)
+	defer bastion.listener.Close()
+	server := newTestSSHServer(t)
+	defer server.listener.Close()
+	pin := filepath.Join(dir, hostKeyFileName)
+	if err := ioutil.WriteFile(pin, ssh.MarshalAuthorizedKey(server.hostKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	echo := echoServer(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	remote, _ := strconv.Atoi(echoPort)
+	_, serverPort, _ := net.SplitHostPort(server.listener.Addr().String())
+	targetPort, _ := strconv.Atoi(serverPort)
+
+	f := &forwarder{spec: &tunnelSpec{
+		Bastion:        "jump@" + bastion.listener.Addr().String(),
+		BastionKey:     keyPath,
+		BastionHostKey: filepath.Join(dir, bastionHostKeyFile),
+		Target:         "127.0.0.1",
+		TargetPort:     targetPort,
+		TargetUser:     "docker",
+		TargetKey:      keyPath,
+		TargetHostKey:  pin,
+	}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go f.serve(listener, remote)
+
+	roundTrip(t, listener.Addr().String(), "first")
+	server.drop()
+	roundTrip(t, listener.Addr().String(), "second")
+	if server.loginCount() != 2 {
+		t.Errorf("Expected a reconnection to the server, got %d logins", server.loginCount())
+	}
+
+	other, _, err := generateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pin, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.drop()
+	if _, err := f.dial(remote); err == nil {
+		t.Error("Forwarded to a server with a different host key")
+	}
+}
+
+func TestAllocateTunnelPorts(t *testing.T) {
+	driver := new(Driver)
+	if err := driver.allocateTunnelPorts(); err != nil {
+		t.Fatal(err)
+	}
+	if driver.TunnelSSHPort == 0 || driver.TunnelDockerPort != 0 {
+		t.Fatalf("Incorrect ports allocated without a bastion: %d, %d", driver.TunnelSSHPort, driver.TunnelDockerPort)
+	}
+	driver.Bastion = "jump@bastion.example.com"
+	if err := driver.allocateTunnelPorts(); err != nil {
+		t.Fatal(err)
+	}
+	if driver.TunnelSSHPort == 0 || driver.TunnelDockerPort == 0 {
+		t.Fatalf("Ports not allocated: %d, %d", driver.TunnelSSHPort, driver.TunnelDockerPort)
+	}
+	sshPort := driver.TunnelSSHPort
+	if err := driver.allocateTunnelPorts(); err != nil {
+		t.Fatal(err)
+	}
+	if driver.TunnelSSHPort != sshPort {
+		t.Error("Saved tunnel port replaced")
+	}
+}
+
+func TestBastionKeyValidation(t *testing.T) {
+	driver := new(Driver)
+	flags := getDefaultTestDriverFlags(driver)
+	flags.Data["brightbox-bastion"] = "jump@bastion.example.com"
+	flags.Data["brightbox-bastion-key"] = "/no/such/key"
+	if err := driver.SetConfigFromFlags(flags); err == nil {
+		t.Error("Missing bastion key not picked up")
+	}
+}
+
+func TestListenForwardsTakenPort(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+	listeners, forwards, err := listenForwards([]portForward{{Local: port, Remote: 22}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].Close()
+	if forwards[0].Local == port || forwards[0].Local != listeners[0].Addr().(*net.TCPAddr).Port {
+		t.Errorf("Taken port not replaced: %d", forwards[0].Local)
+	}
+	if forwards[0].Remote != 22 {
+		t.Errorf("Remote port changed: %d", forwards[0].Remote)
+	}
+}
+
+func TestRunningTunnelChecksProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brightbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	driver := NewDriver("test", dir)
+	path := driver.ResolveStorePath(tunnelStateFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	forwards := []portForward{{Local: 2222, Remote: 22}}
+	if err := writeTunnelState(path, &tunnelState{Pid: os.Getpid(), Forwards: forwards}); err != nil {
+		t.Fatal(err)
+	}
+	if state := driver.runningTunnel(); state == nil || state.Forwards[0].Local != 2222 {
+		t.Errorf("Running forwarder not found: %v", state)
+	}
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip("Unable to run a short lived process")
+	}
+	if err := writeTunnelState(path, &tunnelState{Pid: exited.Process.Pid, Forwards: forwards}); err != nil {
+		t.Fatal(err)
+	}
+	if state := driver.runningTunnel(); state != nil {
+		t.Error("Forwarder that has exited treated as running")
+	}
+}
@@ -0,0 +1,88 @@
+package brightbox
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const (
+	otpHeader   = "X-Brightbox-OTP"
+	otpRequired = "required"
+	otpEnvVar   = "BRIGHTBOX_OTP"
+)
+
+// otpTransport adds a one time password to token requests and notes
+// when the token endpoint asks for one.
+type otpTransport struct {
+	base     http.RoundTripper
+	otp      string
+	required bool
+}
+
+func (t *otpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.otp != "" {
+		clone := *req
+		clone.Header = make(http.Header, len(req.Header)+1)
+		for key, value := range req.Header {
+			clone.Header[key] = value
+		}
+		clone.Header.Set(otpHeader, t.otp)
+		req = &clone
+	}
+	res, err := t.base.RoundTrip(req)
+	if err == nil && strings.EqualFold(res.Header.Get(otpHeader), otpRequired) {
+		t.required = true
+	}
+	return res, err
+}
+
+// contextTransport returns the transport of the HTTP client carried by
+// ctx, or the default transport.
+func contextTransport(ctx context.Context) http.RoundTripper {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client.Transport != nil {
+		return client.Transport
+	}
+	return http.DefaultTransport
+}
+
+// oneTimePassword returns the code given on the command line or in the
+// environment.
+func (authd *authdetails) oneTimePassword() string {
+	if authd.otp != "" {
+		return authd.otp
+	}
+	return os.Getenv(otpEnvVar)
+}
+
+// passwordToken obtains a token with the user's credentials. If the
+// user has two factor authentication and no code has been supplied,
+// it asks for one on the terminal and tries again, with the token
+// cache unlocked while it waits for an answer.
+func (authd *authdetails) passwordToken(ctx context.Context, conf *oauth2.Config, cache *tokenCache) (*oauth2.Token, error) {
+	transport := &otpTransport{
+		base: contextTransport(ctx),
+		otp:  authd.oneTimePassword(),
+	}
+	otpCtx := context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	token, err := conf.PasswordCredentialsToken(otpCtx, authd.userName(), authd.userPassword())
+	if err == nil || !transport.required || transport.otp != "" {
+		return token, err
+	}
+	log.Debug("Token endpoint requires a two factor authentication code")
+	var otp string
+	var promptErr error
+	cache.unlocked(func() {
+		otp, promptErr = promptLine("Brightbox two factor authentication code: ")
+	})
+	if promptErr != nil {
+		log.Debugf("Unable to prompt for code: %s", promptErr)
+		return nil, err
+	}
+	transport.otp = otp
+	return conf.PasswordCredentialsToken(otpCtx, authd.userName(), authd.userPassword())
+}
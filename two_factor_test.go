@@ -0,0 +1,58 @@
+package brightbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func newTwoFactorTokenServer(t *testing.T, code string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(otpHeader) != code {
+			w.Header().Set(otpHeader, otpRequired)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tokentoken","token_type":"Bearer","expires_in":7200}`))
+	}))
+}
+
+func TestPasswordTokenWithOTP(t *testing.T) {
+	server := newTwoFactorTokenServer(t, "123456")
+	defer server.Close()
+	authd := &authdetails{
+		APIURL:   server.URL,
+		UserName: "fred@example.com",
+		password: "hunter2",
+		otp:      "123456",
+	}
+	conf := &oauth2.Config{
+		ClientID: "app-12345",
+		Endpoint: oauth2.Endpoint{TokenURL: authd.tokenURL()},
+	}
+	token, err := authd.passwordToken(oauth2.NoContext, conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "tokentoken" {
+		t.Errorf("Incorrect token: %s", token.AccessToken)
+	}
+}
+
+func TestOTPTransportDetectsRequirement(t *testing.T) {
+	server := newTwoFactorTokenServer(t, "123456")
+	defer server.Close()
+	transport := &otpTransport{base: http.DefaultTransport}
+	res, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if !transport.required {
+		t.Error("Two factor requirement not detected")
+	}
+}
@@ -0,0 +1,151 @@
+package brightbox
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+// How long each lifecycle operation waits for the server to reach its
+// target state. Stop has its own configurable timeout.
+const (
+	createTimeout  = 10 * time.Minute
+	startTimeout   = 5 * time.Minute
+	restartTimeout = 5 * time.Minute
+	killTimeout    = 2 * time.Minute
+	removeTimeout  = 5 * time.Minute
+)
+
+// How often the server status is checked while waiting for a change
+var statePollInterval = 5 * time.Second
+
+// stateTimeoutError reports a server that didn't reach the expected
+// state in time, along with the state it was last seen in.
+type stateTimeoutError struct {
+	machineID string
+	target    state.State
+	observed  state.State
+	timeout   time.Duration
+}
+
+func (e *stateTimeoutError) Error() string {
+	return fmt.Sprintf("Server %s did not reach state %s within %s. Last state was %s",
+		e.machineID, e.target, e.timeout, e.observed)
+}
+
+// waitForState polls getState until it returns target or timeout
+// passes. A failed server will never get there, so the wait ends early.
+func waitForState(machineID string, getState func() (state.State, error), target state.State, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := getState()
+		if err != nil {
+			return err
+		}
+		if current == target {
+			return nil
+		}
+		if current == state.Error {
+			return fmt.Errorf("Server %s has failed", machineID)
+		}
+		if !time.Now().Before(deadline) {
+			return &stateTimeoutError{
+				machineID: machineID,
+				target:    target,
+				observed:  current,
+				timeout:   timeout,
+			}
+		}
+		log.Debugf("Server %s is %s, waiting for %s", machineID, current, target)
+		time.Sleep(statePollInterval)
+	}
+}
+
+func (d *Driver) waitForState(target state.State, timeout time.Duration) error {
+	return waitForState(d.MachineID, d.GetState, target, timeout)
+}
+
+// bootIDCommand prints an ID that changes each time the server boots
+const bootIDCommand = "cat /proc/sys/kernel/random/boot_id"
+
+// waitForBoot polls bootID until the server answers over SSH with a
+// boot ID other than previous, or timeout passes. A rebooting server
+// stays active throughout, so its status can't show when the reboot
+// has finished.
+func waitForBoot(machineID string, bootID func() (string, error), previous string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := bootID()
+		if err == nil && current != "" && current != previous {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			if err == nil {
+				err = fmt.Errorf("still running boot %s", current)
+			}
+			return fmt.Errorf("Server %s not reachable over SSH within %s: %s", machineID, timeout, err)
+		}
+		log.Debugf("Waiting for SSH on server %s", machineID)
+		time.Sleep(statePollInterval)
+	}
+}
+
+// bootID reads the server's current boot ID over SSH
+func (d *Driver) bootID() (string, error) {
+	output, err := drivers.RunSSHCommandFromDriver(d, bootIDCommand)
+	return strings.TrimSpace(output), err
+}
+
+// waitForSSH waits until the server can be logged in to over SSH,
+// having booted again if previous is set.
+func (d *Driver) waitForSSH(previous string, timeout time.Duration) error {
+	return waitForBoot(d.MachineID, d.bootID, previous, timeout)
+}
+
+// alreadyInState checks whether the server is already in the target
+// state, leaving a lifecycle operation nothing to do.
+func alreadyInState(machineID string, getState func() (state.State, error), target state.State) (bool, error) {
+	current, err := getState()
+	if err != nil {
+		return false, fmt.Errorf("Checking status of server %s failed: %s", machineID, err)
+	}
+	if current == target {
+		log.Infof("Server %s is already %s", machineID, target)
+		return true, nil
+	}
+	return false, nil
+}
+
+// deletionState is GetState for a server being removed. Only a deleted
+// or vanished server counts as stopped, as inactive servers also show
+// as stopped in GetState.
+func (d *Driver) deletionState() (state.State, error) {
+	server, err := d.getServerDetails()
+	switch {
+	case isNotFound(err):
+		return state.Stopped, nil
+	case err != nil:
+		return state.Error, err
+	case server.Status == "deleted":
+		return state.Stopped, nil
+	default:
+		log.Debugf("Server %s is %s", d.MachineID, server.Status)
+		return state.Stopping, nil
+	}
+}
+
+func isNotFound(err error) bool {
+	switch apierr := err.(type) {
+	case brightbox.ApiError:
+		return apierr.StatusCode == http.StatusNotFound
+	case *brightbox.ApiError:
+		return apierr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package brightbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brightbox/gobrightbox"
+	"github.com/docker/machine/libmachine/state"
+)
+
+// stateSequence returns each state in turn, then repeats the last one
+func stateSequence(states ...state.State) func() (state.State, error) {
+	return func() (state.State, error) {
+		current := states[0]
+		if len(states) > 1 {
+			states = states[1:]
+		}
+		return current, nil
+	}
+}
+
+func init() {
+	statePollInterval = time.Millisecond
+}
+
+func TestWaitForStateReached(t *testing.T) {
+	getState := stateSequence(state.Running, state.Stopping, state.Stopped)
+	if err := waitForState("srv-testy", getState, state.Stopped, time.Second); err != nil {
+		t.Errorf("Target state not detected: %s", err)
+	}
+}
+
+func TestWaitForStateTimeout(t *testing.T) {
+	getState := stateSequence(state.Running)
+	err := waitForState("srv-testy", getState, state.Stopped, 10*time.Millisecond)
+	timeout, ok := err.(*stateTimeoutError)
+	if !ok {
+		t.Fatalf("Timeout not detected: %v", err)
+	}
+	if timeout.observed != state.Running {
+		t.Errorf("Incorrect observed state: %s", timeout.observed)
+	}
+}
+
+func TestWaitForStateError(t *testing.T) {
+	failure := errors.New("API unavailable")
+	getState := func() (state.State, error) {
+		return state.Error, failure
+	}
+	if err := waitForState("srv-testy", getState, state.Stopped, time.Second); err != failure {
+		t.Errorf("State error not returned: %v", err)
+	}
+}
+
+func TestStopTimeoutDefault(t *testing.T) {
+	driver := new(Driver)
+	if driver.stopTimeout() != defaultStopTimeout*time.Second {
+		t.Errorf("Incorrect default stop timeout: %s", driver.stopTimeout())
+	}
+	driver.StopTimeout = 30
+	if driver.stopTimeout() != 30*time.Second {
+		t.Errorf("Stop timeout not used: %s", driver.stopTimeout())
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !isNotFound(brightbox.ApiError{StatusCode: 404}) {
+		t.Errorf("404 not detected")
+	}
+	if !isNotFound(&brightbox.ApiError{StatusCode: 404}) {
+		t.Errorf("404 pointer not detected")
+	}
+	if isNotFound(brightbox.ApiError{StatusCode: 500}) {
+		t.Errorf("500 treated as not found")
+	}
+	if isNotFound(errors.New("not found")) {
+		t.Errorf("Plain error treated as not found")
+	}
+}
+
+func TestAlreadyInState(t *testing.T) {
+	done, err := alreadyInState("srv-testy", stateSequence(state.Running), state.Running)
+	if !done || err != nil {
+		t.Errorf("Current state not detected: %v", err)
+	}
+	done, err = alreadyInState("srv-testy", stateSequence(state.Stopped), state.Running)
+	if done || err != nil {
+		t.Errorf("Different state treated as reached: %v", err)
+	}
+	failing := func() (state.State, error) {
+		return state.Error, errors.New("API unavailable")
+	}
+	if done, err = alreadyInState("srv-testy", failing, state.Running); done || err == nil {
+		t.Errorf("State error not returned")
+	}
+}
+
+func TestWaitForStateFailed(t *testing.T) {
+	getState := stateSequence(state.Starting, state.Error)
+	if err := waitForState("srv-testy", getState, state.Running, time.Second); err == nil {
+		t.Error("Failed server not detected")
+	}
+}
+
+// bootSequence returns each boot ID in turn, failing on empty ones as
+// SSH does while the server is down.
+func bootSequence(ids ...string) func() (string, error) {
+	return func() (string, error) {
+		current := ids[0]
+		if len(ids) > 1 {
+			ids = ids[1:]
+		}
+		if current == "" {
+			return "", errors.New("Connection refused")
+		}
+		return current, nil
+	}
+}
+
+func TestWaitForBootAfterReboot(t *testing.T) {
+	bootID := bootSequence("boot-1", "boot-1", "", "boot-2")
+	if err := waitForBoot("srv-testy", bootID, "boot-1", time.Second); err != nil {
+		t.Errorf("New boot not detected: %s", err)
+	}
+}
+
+func TestWaitForBootTimeout(t *testing.T) {
+	if err := waitForBoot("srv-testy", bootSequence("boot-1"), "boot-1", 10*time.Millisecond); err == nil {
+		t.Error("Reboot that never happened not detected")
+	}
+	if err := waitForBoot("srv-testy", bootSequence(""), "", 10*time.Millisecond); err == nil {
+		t.Error("Unreachable server not detected")
+	}
+}